@@ -0,0 +1,358 @@
+package cache_warmer
+
+import (
+	"fmt"
+	"github.com/NitinD97/common-utils/connectors/redis"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/NitinD97/common-utils/rate_limiter"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Entry is a value to be written to the cache.
+type Entry struct {
+	Key string
+	// Value is stored as it is if it is a string and JSON encoded otherwise.
+	Value interface{}
+	TTL   time.Duration
+	Tags  []string
+}
+
+// Loader produces the entries to warm page by page. Load returns the entries
+// following cursor, "" for the first page, and the cursor of the next page,
+// or "" once there are no more entries.
+type Loader interface {
+	Name() string
+	Load(ctx *context.Context, cursor string, limit int) ([]Entry, string, error)
+}
+
+type Config struct {
+	// RunId identifies a warm-up, e.g. the deployed version. An interrupted run
+	// restarted with the same RunId resumes from its checkpoints, which are
+	// deleted once every loader is done.
+	RunId string `json:"run_id"`
+	// BatchSize is the number of entries loaded per page, 500 by default.
+	BatchSize int `json:"batch_size"`
+	// Concurrency bounds the cache writes in flight across all loaders, 8 by
+	// default.
+	Concurrency int `json:"concurrency"`
+	// RateLimitKey is the rate limiter key every write is counted against,
+	// "cache_warmer" by default.
+	RateLimitKey string `json:"rate_limit_key"`
+	// CheckpointTTL is how long checkpoints are kept, 24h by default.
+	CheckpointTTL time.Duration `json:"checkpoint_ttl"`
+	// ProgressInterval between two progress logs, 10s by default.
+	ProgressInterval time.Duration `json:"progress_interval"`
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.RunId == "" {
+		cfg.RunId = "default"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 8
+	}
+	if cfg.RateLimitKey == "" {
+		cfg.RateLimitKey = "cache_warmer"
+	}
+	if cfg.CheckpointTTL <= 0 {
+		cfg.CheckpointTTL = 24 * time.Hour
+	}
+	if cfg.ProgressInterval <= 0 {
+		cfg.ProgressInterval = 10 * time.Second
+	}
+	return cfg
+}
+
+type loaderFunc struct {
+	name string
+	load func(ctx *context.Context, cursor string, limit int) ([]Entry, string, error)
+}
+
+// NewLoader adapts a load function to a Loader.
+func NewLoader(name string, load func(ctx *context.Context, cursor string, limit int) ([]Entry, string, error)) Loader {
+	return &loaderFunc{name: name, load: load}
+}
+
+func (l *loaderFunc) Name() string {
+	return l.name
+}
+
+func (l *loaderFunc) Load(ctx *context.Context, cursor string, limit int) ([]Entry, string, error) {
+	return l.load(ctx, cursor, limit)
+}
+
+// checkpoint is the progress of a loader, saved after every written page.
+type checkpoint struct {
+	Cursor string `json:"cursor"`
+	Done   bool   `json:"done"`
+	Loaded int64  `json:"loaded"`
+}
+
+type Warmer struct {
+	cache   *redis.Cache
+	cfg     Config
+	keys    *redis.KeyBuilder
+	limiter *rate_limiter.Limiter
+	logger  *zap.Logger
+}
+
+type WarmerOption func(*Warmer)
+
+// WithLimiter throttles cache writes through limiter.
+func WithLimiter(limiter *rate_limiter.Limiter) WarmerOption {
+	return func(w *Warmer) {
+		w.limiter = limiter
+	}
+}
+
+// WithKeyBuilder sets the builder of the checkpoint keys, built from the
+// configuration by default.
+func WithKeyBuilder(keys *redis.KeyBuilder) WarmerOption {
+	return func(w *Warmer) {
+		w.keys = keys
+	}
+}
+
+func NewWarmer(cache *redis.Cache, cfg Config, logger *zap.Logger, opts ...WarmerOption) *Warmer {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	warmer := &Warmer{
+		cache:  cache,
+		cfg:    cfg.withDefaults(),
+		logger: logger,
+	}
+	for _, opt := range opts {
+		opt(warmer)
+	}
+	if warmer.keys == nil {
+		warmer.keys = redis.NewKeyBuilderFromConfig()
+	}
+	return warmer
+}
+
+// progress counts the entries written by a loader.
+type progress struct {
+	name   string
+	loaded atomic.Int64
+	done   atomic.Bool
+}
+
+// Warm runs all loaders concurrently and writes their entries to the cache.
+// Loaders finished in an earlier, interrupted attempt of the same run are
+// skipped, the others continue after their last written page. It returns once
+// every loader is done, with the errors of the failed ones. Checkpoints are
+// deleted when all loaders succeed, so the next Warm starts over.
+func (w *Warmer) Warm(ctx *context.Context, loaders ...Loader) error {
+	start := time.Now()
+	slots := make(chan struct{}, w.cfg.Concurrency)
+	progresses := make([]*progress, len(loaders))
+	for i, loader := range loaders {
+		progresses[i] = &progress{name: loader.Name()}
+	}
+	errs := make([]error, len(loaders))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.logProgress(ctx, progresses, start, stop)
+
+	var wg sync.WaitGroup
+	for i, loader := range loaders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = w.warm(ctx, loader, progresses[i], slots)
+		}()
+	}
+	wg.Wait()
+
+	var failed error
+	var loaded int64
+	for i, err := range errs {
+		loaded += progresses[i].loaded.Load()
+		if err != nil {
+			w.logger.Error("Cache warming failed",
+				zap.String("loader", loaders[i].Name()),
+				zap.Int64("loaded", progresses[i].loaded.Load()),
+				zap.Any(enums.RequestId, ctx.Get(enums.RequestId)),
+				zap.Error(err),
+			)
+			if failed == nil {
+				failed = err
+			}
+		}
+	}
+	if failed == nil {
+		w.clearCheckpoints(ctx, loaders)
+	}
+	w.logger.Info("Cache warming finished",
+		zap.String("runId", w.cfg.RunId),
+		zap.Int64("loaded", loaded),
+		zap.Duration("duration", time.Since(start)),
+		zap.Bool("failed", failed != nil),
+	)
+	return failed
+}
+
+// checkpointKey is namespaced by environment and service, so services sharing
+// a Redis DB and a RunId do not resume from each other's checkpoints.
+func (w *Warmer) checkpointKey(loader Loader) string {
+	return w.keys.Key("cache_warmer", w.cfg.RunId, loader.Name())
+}
+
+// clearCheckpoints deletes the checkpoints of a completed run. A checkpoint
+// left behind only makes the next run skip its loader until CheckpointTTL, so
+// failures are logged and not returned.
+func (w *Warmer) clearCheckpoints(ctx *context.Context, loaders []Loader) {
+	for _, loader := range loaders {
+		if err := w.cache.Delete(ctx, w.checkpointKey(loader)); err != nil {
+			w.logger.Warn("Failed to delete cache warming checkpoint",
+				zap.String("loader", loader.Name()),
+				zap.String("runId", w.cfg.RunId),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+func (w *Warmer) warm(ctx *context.Context, loader Loader, progress *progress, slots chan struct{}) error {
+	checkpointKey := w.checkpointKey(loader)
+	var state checkpoint
+	if err := w.cache.GetJSON(ctx, checkpointKey, &state); err != nil && !errors.Is(err, redis.ErrKeyNotFound) {
+		return errors.Wrap(err, fmt.Sprintf("failed to read checkpoint of loader %s", loader.Name()))
+	}
+	progress.loaded.Store(state.Loaded)
+	if state.Done {
+		progress.done.Store(true)
+		w.logger.Info("Cache warming already done, skipping loader",
+			zap.String("loader", loader.Name()),
+			zap.String("runId", w.cfg.RunId),
+		)
+		return nil
+	}
+	if state.Cursor != "" {
+		w.logger.Info("Resuming cache warming",
+			zap.String("loader", loader.Name()),
+			zap.String("cursor", state.Cursor),
+			zap.Int64("loaded", state.Loaded),
+		)
+	}
+
+	for {
+		if ctx.Context.Err() != nil {
+			return ctx.Context.Err()
+		}
+		entries, next, err := loader.Load(ctx, state.Cursor, w.cfg.BatchSize)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("loader %s failed after cursor %q", loader.Name(), state.Cursor))
+		}
+		if err := w.write(ctx, entries, slots); err != nil {
+			return err
+		}
+		progress.loaded.Add(int64(len(entries)))
+
+		state = checkpoint{Cursor: next, Done: next == "", Loaded: progress.loaded.Load()}
+		if err := w.cache.SetJson(ctx, checkpointKey, state, w.cfg.CheckpointTTL); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to save checkpoint of loader %s", loader.Name()))
+		}
+		if state.Done {
+			progress.done.Store(true)
+			return nil
+		}
+	}
+}
+
+// write sets entries with at most Concurrency writes in flight, and returns
+// once all of them are written so the page can be checkpointed.
+func (w *Warmer) write(ctx *context.Context, entries []Entry, slots chan struct{}) error {
+	var wg sync.WaitGroup
+	var failed atomic.Pointer[error]
+	for _, entry := range entries {
+		if failed.Load() != nil {
+			break
+		}
+		if err := w.throttle(ctx); err != nil {
+			wg.Wait()
+			return err
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Context.Done():
+			wg.Wait()
+			return ctx.Context.Err()
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			var err error
+			if value, ok := entry.Value.(string); ok {
+				err = w.cache.Set(ctx, entry.Key, value, entry.TTL, entry.Tags...)
+			} else {
+				err = w.cache.SetJson(ctx, entry.Key, entry.Value, entry.TTL, entry.Tags...)
+			}
+			if err != nil {
+				err = errors.Wrap(err, fmt.Sprintf("failed to warm key %s", entry.Key))
+				failed.CompareAndSwap(nil, &err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := failed.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// throttle waits until the limiter allows the next write.
+func (w *Warmer) throttle(ctx *context.Context) error {
+	if w.limiter == nil {
+		return nil
+	}
+	for {
+		result, err := w.limiter.Allow(ctx.Context, w.cfg.RateLimitKey)
+		if err != nil {
+			return errors.Wrap(err, "failed to check cache warming rate limit")
+		}
+		if result.Allowed > 0 {
+			return nil
+		}
+		select {
+		case <-time.After(max(result.RetryAfter, time.Millisecond)):
+		case <-ctx.Context.Done():
+			return ctx.Context.Err()
+		}
+	}
+}
+
+func (w *Warmer) logProgress(ctx *context.Context, progresses []*progress, start time.Time, stop chan struct{}) {
+	ticker := time.NewTicker(w.cfg.ProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Context.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, progress := range progresses {
+			w.logger.Info("Cache warming in progress",
+				zap.String("loader", progress.name),
+				zap.Int64("loaded", progress.loaded.Load()),
+				zap.Bool("done", progress.done.Load()),
+				zap.Duration("elapsed", time.Since(start)),
+			)
+		}
+	}
+}
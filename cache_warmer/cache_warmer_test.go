@@ -0,0 +1,53 @@
+package cache_warmer_test
+
+import (
+	"github.com/NitinD97/common-utils/cache_warmer"
+	"github.com/NitinD97/common-utils/connectors/redis"
+	"github.com/NitinD97/common-utils/connectors/redis/redistest"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/errors"
+	"testing"
+	"time"
+)
+
+// interruptedLoader writes one page, then fails, leaving a checkpoint behind.
+// It records the cursors it was asked to load from.
+func interruptedLoader(cursors *[]string) cache_warmer.Loader {
+	return cache_warmer.NewLoader("users", func(ctx *context.Context, cursor string, limit int) ([]cache_warmer.Entry, string, error) {
+		*cursors = append(*cursors, cursor)
+		if cursor != "" {
+			return nil, "", errors.New("database is gone")
+		}
+		return []cache_warmer.Entry{{Key: "user:1", Value: "a", TTL: time.Minute}}, "1", nil
+	})
+}
+
+func TestCheckpointsAreNamespacedByService(t *testing.T) {
+	cache, server := redistest.NewCache(t)
+	ctx := context.NewContext()
+	cfg := cache_warmer.Config{RunId: "v1"}
+
+	var cursors []string
+	orders := cache_warmer.NewWarmer(cache, cfg, nil, cache_warmer.WithKeyBuilder(redis.NewKeyBuilder("prod", "orders")))
+	if err := orders.Warm(ctx, interruptedLoader(&cursors)); err == nil {
+		t.Fatal("expected the interrupted warm-up to fail")
+	}
+	if !server.Exists("prod:orders:cache_warmer:v1:users") {
+		t.Fatalf("expected a namespaced checkpoint, got keys %v", server.Keys())
+	}
+
+	// Another service with the same RunId starts over.
+	cursors = nil
+	payments := cache_warmer.NewWarmer(cache, cfg, nil, cache_warmer.WithKeyBuilder(redis.NewKeyBuilder("prod", "payments")))
+	_ = payments.Warm(ctx, interruptedLoader(&cursors))
+	if len(cursors) == 0 || cursors[0] != "" {
+		t.Fatalf("expected payments to start from the first page, got cursors %q", cursors)
+	}
+
+	// The same service resumes after its last written page.
+	cursors = nil
+	_ = orders.Warm(ctx, interruptedLoader(&cursors))
+	if len(cursors) != 1 || cursors[0] != "1" {
+		t.Fatalf("expected orders to resume from its checkpoint, got cursors %q", cursors)
+	}
+}
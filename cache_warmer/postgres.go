@@ -0,0 +1,65 @@
+package cache_warmer
+
+import (
+	"github.com/NitinD97/common-utils/context"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ScanFunc turns the current row into an Entry and returns the cursor of the
+// row, which is passed back to the query to load the rows following it.
+type ScanFunc func(rows pgx.Rows) (Entry, string, error)
+
+// QueryLoader pages through a keyset paginated query. The query receives the
+// cursor of the last row of the previous page as $1, "" for the first page,
+// and the page size as $2:
+//
+//	SELECT id, profile FROM users
+//	WHERE $1 = '' OR id > $1::text::bigint
+//	ORDER BY id LIMIT $2
+type QueryLoader struct {
+	name  string
+	pool  *pgxpool.Pool
+	query string
+	scan  ScanFunc
+}
+
+func NewQueryLoader(name string, pool *pgxpool.Pool, query string, scan ScanFunc) *QueryLoader {
+	return &QueryLoader{
+		name:  name,
+		pool:  pool,
+		query: query,
+		scan:  scan,
+	}
+}
+
+func (l *QueryLoader) Name() string {
+	return l.name
+}
+
+func (l *QueryLoader) Load(ctx *context.Context, cursor string, limit int) ([]Entry, string, error) {
+	rows, err := l.pool.Query(ctx.Context, l.query, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	entries := make([]Entry, 0, limit)
+	last := ""
+	for rows.Next() {
+		entry, rowCursor, err := l.scan(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		entries = append(entries, entry)
+		last = rowCursor
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	// A short page is the last one.
+	if len(entries) < limit {
+		return entries, "", nil
+	}
+	return entries, last, nil
+}
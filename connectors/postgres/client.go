@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"context"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"time"
+)
+
+// Client owns a connection pool. Unlike the pool returned by Init, it is not
+// stored in a package variable, so a service can hold several of them.
+type Client struct {
+	pool   *pgxpool.Pool
+	logger *zap.Logger
+}
+
+// NewClient connects to the database. With WithShutdown, the client is closed
+// when the manager shuts down.
+func NewClient(pgConfig PgConfig, logger *zap.Logger, opts ...InitOption) (*Client, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	pool, options, err := connect(pgConfig, logger, opts)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{pool: pool, logger: logger}
+	if options.shutdown != nil {
+		options.shutdown.Register("postgres", client)
+	}
+	return client, nil
+}
+
+// Pool returns the pool of the client, e.g. for QueryOne or WithTx.
+func (c *Client) Pool() *pgxpool.Pool {
+	return c.pool
+}
+
+// Close stops handing out connections and waits for the ones in use to be
+// released, so in-flight queries and transactions can finish. It returns an
+// error if they are still running when ctx is done; the pool is then closed as
+// soon as they finish.
+func (c *Client) Close(ctx context.Context) error {
+	return closePool(ctx, c.pool, c.logger)
+}
+
+func closePool(ctx context.Context, pool *pgxpool.Pool, logger *zap.Logger) error {
+	start := time.Now()
+	inUse := pool.Stat().AcquiredConns()
+	closed := make(chan struct{})
+	go func() {
+		pool.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		// Closing an idle pool is quick even if ctx is already done.
+		select {
+		case <-closed:
+		case <-time.After(10 * time.Millisecond):
+			return errors.Wrap(ctx.Err(), "connections still in use when closing postgres pool")
+		}
+	}
+	logger.Info("Postgres pool closed",
+		zap.Int32("inUse", inUse),
+		zap.Duration("duration", time.Since(start)),
+	)
+	return nil
+}
@@ -0,0 +1,223 @@
+package postgres
+
+import (
+	stdcontext "context"
+	"fmt"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/NitinD97/common-utils/health"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	RoutingRoundRobin   = "round_robin"
+	RoutingLeastLatency = "least_latency"
+)
+
+type ClusterConfig struct {
+	Primary  PgConfig   `json:"primary"`
+	Replicas []PgConfig `json:"replicas"`
+	// Routing picks the replica serving a read, RoutingRoundRobin (default) or
+	// RoutingLeastLatency by the latency of the last health check.
+	Routing string `json:"routing"`
+	// ReplicaHealth configures the health checks taking replicas out of
+	// rotation. Replicas are optional dependencies since reads fall back to
+	// the primary.
+	ReplicaHealth health.MonitorConfig `json:"replica_health"`
+}
+
+func (cfg ClusterConfig) validate() error {
+	switch cfg.Routing {
+	case "", RoutingRoundRobin, RoutingLeastLatency:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("unknown postgres routing %q", cfg.Routing))
+}
+
+type replica struct {
+	pool    *pgxpool.Pool
+	monitor *health.Monitor
+}
+
+// Cluster routes writes to a primary and reads to its replicas. Replicas
+// failing their health checks are dropped out of rotation until they recover,
+// and reads go to the primary while no replica is healthy.
+type Cluster struct {
+	primary  *pgxpool.Pool
+	replicas []*replica
+	routing  string
+	next     atomic.Uint64
+	logger   *zap.Logger
+}
+
+// InitCluster connects to the primary and every replica. The tracer set by
+// WithTracer or WithTracerOptions instruments every pool; the other opts apply
+// to the primary only, so migrations run there, except WithShutdown which
+// closes the whole cluster. The replicas are checked once before InitCluster returns; Run
+// keeps checking them.
+func InitCluster(cfg ClusterConfig, logger *zap.Logger, opts ...InitOption) (*Cluster, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	primary, options, err := connect(cfg.Primary, logger, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to primary")
+	}
+	cluster := &Cluster{
+		primary: primary,
+		routing: cfg.Routing,
+		logger:  logger,
+	}
+
+	replicaOpts := []InitOption{WithTracerOptions(options.tracerOptions...)}
+	if options.tracer != nil {
+		replicaOpts = append(replicaOpts, WithTracer(options.tracer))
+	}
+	monitorCfg := cfg.ReplicaHealth
+	monitorCfg.Optional = true
+	for i, replicaCfg := range cfg.Replicas {
+		pool, _, err := connect(replicaCfg, logger, replicaOpts)
+		if err != nil {
+			cluster.closePools()
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to connect to replica %s", replicaCfg.Host))
+		}
+		name := fmt.Sprintf("postgres-replica-%d", i)
+		cluster.replicas = append(cluster.replicas, &replica{
+			pool:    pool,
+			monitor: NewHealthMonitor(name, pool, monitorCfg, logger),
+		})
+	}
+
+	ctx := context.NewContext()
+	var wg sync.WaitGroup
+	for _, replica := range cluster.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replica.monitor.Check(ctx)
+		}()
+	}
+	wg.Wait()
+	if options.shutdown != nil {
+		options.shutdown.Register("postgres-cluster", cluster)
+	}
+	return cluster, nil
+}
+
+// Primary returns the pool of the primary, for writes.
+func (c *Cluster) Primary() *pgxpool.Pool {
+	return c.primary
+}
+
+// Reader returns the pool serving reads for ctx: the primary if ctx carries a
+// transaction or was passed to UsePrimary, a healthy replica otherwise.
+func (c *Cluster) Reader(ctx *context.Context) *pgxpool.Pool {
+	if _, ok := TxFromContext(ctx); ok {
+		return c.primary
+	}
+	if primary, _ := ctx.Get(enums.DbPrimary).(bool); primary {
+		return c.primary
+	}
+	if replica := c.pick(); replica != nil {
+		return replica.pool
+	}
+	return c.primary
+}
+
+// WithTx runs fn in a transaction like WithTx, on a replica if opts.ReadOnly is
+// set and on the primary otherwise.
+func (c *Cluster) WithTx(ctx *context.Context, opts TxOptions, fn TxFunc) error {
+	if opts.ReadOnly {
+		return WithTx(ctx, c.Reader(ctx), opts, fn)
+	}
+	return WithTx(ctx, c.primary, opts, fn)
+}
+
+// UsePrimary sends the reads of ctx to the primary, so a request reads its own
+// writes despite the replication lag.
+func UsePrimary(ctx *context.Context) {
+	ctx.Set(enums.DbPrimary, true)
+}
+
+func (c *Cluster) pick() *replica {
+	healthy := make([]*replica, 0, len(c.replicas))
+	for _, replica := range c.replicas {
+		if replica.monitor.Status().Healthy {
+			healthy = append(healthy, replica)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	if c.routing == RoutingLeastLatency {
+		fastest := healthy[0]
+		for _, replica := range healthy[1:] {
+			if replica.monitor.Status().Latency < fastest.monitor.Status().Latency {
+				fastest = replica
+			}
+		}
+		return fastest
+	}
+	return healthy[(c.next.Add(1)-1)%uint64(len(healthy))]
+}
+
+// Monitors returns the health monitors of the replicas, to be registered with
+// a health.Registry instead of calling Run.
+func (c *Cluster) Monitors() []*health.Monitor {
+	monitors := make([]*health.Monitor, len(c.replicas))
+	for i, replica := range c.replicas {
+		monitors[i] = replica.monitor
+	}
+	return monitors
+}
+
+// Run checks the replicas until ctx is cancelled.
+func (c *Cluster) Run(ctx *context.Context) {
+	var wg sync.WaitGroup
+	for _, replica := range c.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replica.monitor.Run(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// Close closes the pools of the primary and the replicas like Client.Close.
+func (c *Cluster) Close(ctx stdcontext.Context) error {
+	pools := []*pgxpool.Pool{c.primary}
+	for _, replica := range c.replicas {
+		pools = append(pools, replica.pool)
+	}
+	errs := make([]error, len(pools))
+	var wg sync.WaitGroup
+	for i, pool := range pools {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = closePool(ctx, pool, c.logger)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) closePools() {
+	c.primary.Close()
+	for _, replica := range c.replicas {
+		replica.pool.Close()
+	}
+}
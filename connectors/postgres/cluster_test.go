@@ -0,0 +1,29 @@
+package postgres
+
+import (
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestInitClusterTracesReplicas(t *testing.T) {
+	// Nothing listens on the port: the pools connect lazily and the replica
+	// health check fails, which leaves the replica out of rotation.
+	unreachable := PgConfig{Host: "127.0.0.1", Port: 1, HealthCheckPeriod: time.Hour}
+	tracer, err := NewTracer(zap.NewNop(), TracerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster, err := InitCluster(ClusterConfig{
+		Primary:  unreachable,
+		Replicas: []PgConfig{unreachable},
+	}, nil, WithTracer(tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.closePools()
+
+	if got := cluster.replicas[0].pool.Config().ConnConfig.Tracer; got != tracer {
+		t.Fatalf("replica traced by %v, want the tracer of the primary", got)
+	}
+}
@@ -0,0 +1,30 @@
+package postgres
+
+import (
+	stderrors "errors"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+)
+
+// ErrMigrationChecksum is returned when the up file of an applied migration
+// was changed after it was applied.
+var ErrMigrationChecksum = errors.New("applied migration was modified")
+
+// sqlState returns the SQLSTATE of the Postgres error in the chain of err, or
+// "" if there is none.
+func sqlState(err error) string {
+	var pgErr *pgconn.PgError
+	if stderrors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// ErrNotFound is returned by QueryOne, wrapped, when the query returns no row.
+// Match it with errors.Is.
+var ErrNotFound = errors.New("no rows found")
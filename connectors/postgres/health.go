@@ -0,0 +1,13 @@
+package postgres
+
+import (
+	"github.com/NitinD97/common-utils/health"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// NewHealthMonitor returns a health.Monitor pinging the database through pool,
+// to be registered with a health.Registry.
+func NewHealthMonitor(name string, pool *pgxpool.Pool, cfg health.MonitorConfig, logger *zap.Logger) *health.Monitor {
+	return health.NewMonitor(name, health.CheckerFunc(pool.Ping), cfg, logger)
+}
@@ -0,0 +1,257 @@
+package postgres
+
+import (
+	stdcontext "context"
+	"fmt"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/goccy/go-json"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Notification is a NOTIFY handed to a NotificationHandler.
+type Notification struct {
+	Channel string
+	Payload string
+	// PID is the process ID of the notifying backend.
+	PID uint32
+}
+
+// Decode unmarshals the JSON payload of the notification into value.
+func (n *Notification) Decode(value any) error {
+	return json.Unmarshal([]byte(n.Payload), value)
+}
+
+// NotificationHandler processes a single notification. Notifications are not
+// redelivered, so a returned error is only logged.
+type NotificationHandler func(ctx *context.Context, n *Notification) error
+
+// TypedHandler adapts a handler taking a decoded JSON payload to a
+// NotificationHandler.
+func TypedHandler[T any](handler func(ctx *context.Context, channel string, payload T) error) NotificationHandler {
+	return func(ctx *context.Context, n *Notification) error {
+		var payload T
+		if err := n.Decode(&payload); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to decode notification on channel %s", n.Channel))
+		}
+		return handler(ctx, n.Channel, payload)
+	}
+}
+
+// GapHandler is called once the listener is subscribed again after losing its
+// connection, with the channels whose notifications sent in the meantime were
+// lost. Consumers resync their state from the database, e.g. flush a cache.
+type GapHandler func(ctx *context.Context, channels []string)
+
+// Notify sends payload JSON encoded on channel. Inside a transaction, the
+// notification is delivered when it commits.
+func Notify(ctx *context.Context, db Querier, channel string, payload any) error {
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode notification")
+	}
+	_, err = querier(ctx, db).Exec(ctx.Context, "SELECT pg_notify($1, $2)", channel, string(bytes))
+	return errors.Wrap(err, fmt.Sprintf("failed to notify channel %s", channel))
+}
+
+type ListenerConfig struct {
+	// PingInterval is how long the connection may stay silent before it is
+	// pinged, so a dead connection is noticed, 30s by default.
+	PingInterval time.Duration `json:"ping_interval"`
+	// ReconnectMin and ReconnectMax bound the exponential backoff between
+	// reconnection attempts, 100ms and 10s by default.
+	ReconnectMin time.Duration `json:"reconnect_min"`
+	ReconnectMax time.Duration `json:"reconnect_max"`
+}
+
+func (cfg ListenerConfig) withDefaults() ListenerConfig {
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = 30 * time.Second
+	}
+	if cfg.ReconnectMin <= 0 {
+		cfg.ReconnectMin = 100 * time.Millisecond
+	}
+	if cfg.ReconnectMax <= 0 {
+		cfg.ReconnectMax = 10 * time.Second
+	}
+	return cfg
+}
+
+// Listener receives notifications on a connection of its own, since a pooled
+// connection would be handed to other queries between notifications.
+type Listener struct {
+	pgConfig PgConfig
+	cfg      ListenerConfig
+	logger   *zap.Logger
+
+	mutex       sync.RWMutex
+	handlers    map[string]NotificationHandler
+	gapHandlers []GapHandler
+}
+
+func NewListener(pgConfig PgConfig, cfg ListenerConfig, logger *zap.Logger) *Listener {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Listener{
+		pgConfig: pgConfig,
+		cfg:      cfg.withDefaults(),
+		logger:   logger,
+		handlers: make(map[string]NotificationHandler),
+	}
+}
+
+// Handle subscribes handler to channel. Channels are listened to from the next
+// (re)connection, so handlers should be registered before Run.
+func (l *Listener) Handle(channel string, handler NotificationHandler) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.handlers[channel] = handler
+}
+
+// OnGap registers a handler called after every reconnection.
+func (l *Listener) OnGap(handler GapHandler) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.gapHandlers = append(l.gapHandlers, handler)
+}
+
+func (l *Listener) channels() []string {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	channels := make([]string, 0, len(l.handlers))
+	for channel := range l.handlers {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// Run listens until ctx is cancelled, reconnecting with backoff whenever the
+// connection is lost. It only returns an error if the first connection fails,
+// so a misconfiguration surfaces at startup.
+func (l *Listener) Run(ctx *context.Context) error {
+	config, err := l.pgConfig.poolConfig()
+	if err != nil {
+		return err
+	}
+	connConfig := config.ConnConfig
+
+	conn, channels, err := l.connect(ctx, connConfig)
+	if err != nil {
+		return err
+	}
+	backoff := l.cfg.ReconnectMin
+	for {
+		err := l.listen(ctx, conn)
+		_ = conn.Close(ctx.WithoutCancel().Context)
+		if ctx.Context.Err() != nil {
+			return nil
+		}
+		l.logger.Warn("Listener connection lost, reconnecting",
+			zap.Strings("channels", channels),
+			zap.Error(err),
+		)
+
+		for {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Context.Done():
+				return nil
+			}
+			conn, channels, err = l.connect(ctx, connConfig)
+			if err == nil {
+				break
+			}
+			if ctx.Context.Err() != nil {
+				return nil
+			}
+			backoff = min(backoff*2, l.cfg.ReconnectMax)
+			l.logger.Warn("Listener failed to reconnect",
+				zap.Duration("retryIn", backoff),
+				zap.Error(err),
+			)
+		}
+		backoff = l.cfg.ReconnectMin
+		l.logger.Info("Listener reconnected", zap.Strings("channels", channels))
+		l.signalGap(ctx, channels)
+	}
+}
+
+// connect opens a connection listening to every channel with a handler.
+func (l *Listener) connect(ctx *context.Context, connConfig *pgx.ConnConfig) (*pgx.Conn, []string, error) {
+	conn, err := pgx.ConnectConfig(ctx.Context, connConfig)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to connect listener")
+	}
+	channels := l.channels()
+	for _, channel := range channels {
+		if _, err := conn.Exec(ctx.Context, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			_ = conn.Close(ctx.WithoutCancel().Context)
+			return nil, nil, errors.Wrap(err, fmt.Sprintf("failed to listen to channel %s", channel))
+		}
+	}
+	return conn, channels, nil
+}
+
+// listen dispatches notifications until the connection fails or ctx is
+// cancelled.
+func (l *Listener) listen(ctx *context.Context, conn *pgx.Conn) error {
+	for {
+		waitCtx, cancel := stdcontext.WithTimeout(ctx.Context, l.cfg.PingInterval)
+		notification, err := conn.WaitForNotification(waitCtx)
+		silent := waitCtx.Err() == stdcontext.DeadlineExceeded
+		cancel()
+		if err != nil {
+			if ctx.Context.Err() != nil {
+				return ctx.Context.Err()
+			}
+			if !silent || conn.IsClosed() {
+				return err
+			}
+			// Nothing was received for PingInterval, check the connection.
+			pingCtx, cancel := stdcontext.WithTimeout(ctx.Context, l.cfg.PingInterval)
+			err = conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		l.dispatch(ctx, notification)
+	}
+}
+
+func (l *Listener) dispatch(ctx *context.Context, notification *pgconn.Notification) {
+	l.mutex.RLock()
+	handler := l.handlers[notification.Channel]
+	l.mutex.RUnlock()
+	if handler == nil {
+		return
+	}
+	n := &Notification{
+		Channel: notification.Channel,
+		Payload: notification.Payload,
+		PID:     notification.PID,
+	}
+	if err := handler(ctx.Clone(), n); err != nil {
+		l.logger.Error("Notification handler failed",
+			zap.String("channel", n.Channel),
+			zap.Error(err),
+		)
+	}
+}
+
+func (l *Listener) signalGap(ctx *context.Context, channels []string) {
+	l.mutex.RLock()
+	gapHandlers := append([]GapHandler(nil), l.gapHandlers...)
+	l.mutex.RUnlock()
+	for _, handler := range gapHandlers {
+		handler(ctx.Clone(), channels)
+	}
+}
@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	stdcontext "context"
+	"github.com/NitinD97/common-utils/connectors/postgres/pgtest"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/jackc/pgx/v5"
+	"testing"
+	"time"
+)
+
+// testPgConfig returns the PgConfig of the test server.
+func testPgConfig(t *testing.T) PgConfig {
+	config, err := pgx.ParseConfig(pgtest.URL(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return PgConfig{
+		Host:     config.Host,
+		Port:     int(config.Port),
+		User:     config.User,
+		Password: config.Password,
+		DbName:   config.Database,
+	}
+}
+
+func TestListenerListensAgainAfterReconnecting(t *testing.T) {
+	pool := pgtest.NewPool(t)
+	pgConfig := testPgConfig(t)
+	pgConfig.ApplicationName = "listener-" + pgtest.Schema(pool)
+	channel := "orders_" + pgtest.Schema(pool)
+
+	listener := NewListener(pgConfig, ListenerConfig{ReconnectMin: 10 * time.Millisecond}, nil)
+	received := make(chan string, 100)
+	listener.Handle(channel, func(ctx *context.Context, n *Notification) error {
+		received <- n.Payload
+		return nil
+	})
+	gaps := make(chan []string, 10)
+	listener.OnGap(func(ctx *context.Context, channels []string) {
+		gaps <- channels
+	})
+
+	ctx := context.NewContext()
+	var cancel stdcontext.CancelFunc
+	ctx.Context, cancel = stdcontext.WithCancel(ctx.Context)
+	done := make(chan error, 1)
+	go func() {
+		done <- listener.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// notifyUntilReceived notifies until the listener, which subscribes in
+	// the background, gets payload.
+	notifyUntilReceived := func(payload string) {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for {
+			if err := Notify(context.NewContext(), pool, channel, payload); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case got := <-received:
+				if got == `"`+payload+`"` {
+					return
+				}
+			case <-time.After(20 * time.Millisecond):
+			case <-deadline:
+				t.Fatalf("notification %s was not received", payload)
+			}
+		}
+	}
+	notifyUntilReceived("before")
+
+	if _, err := pool.Exec(ctx.Context,
+		"SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE application_name = $1",
+		pgConfig.ApplicationName,
+	); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case channels := <-gaps:
+		if len(channels) != 1 || channels[0] != channel {
+			t.Fatalf("unexpected gap on %v", channels)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the listener did not reconnect")
+	}
+	notifyUntilReceived("after")
+}
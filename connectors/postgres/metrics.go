@@ -0,0 +1,113 @@
+package postgres
+
+import (
+	stdcontext "context"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Metrics receives the measurements of the postgres connector, to be exported
+// by an adapter for the metrics library of the service, e.g. as Prometheus
+// histograms and counters.
+type Metrics interface {
+	// ObserveQuery records the duration of a query, batch or copy, failed or
+	// not. name is the query name, see WithQueryName.
+	ObserveQuery(name string, duration time.Duration)
+	// CountError counts a failed query. sqlState is the SQLSTATE reported by
+	// the server, "" for failures on the client side such as a cancelled
+	// context or a lost connection.
+	CountError(name string, sqlState string)
+	// ObservePool records the state of the pool named pool.
+	ObservePool(pool string, stats PoolStats)
+}
+
+// PoolStats is a snapshot of pgxpool.Stat. Counts and durations ending in
+// Total grow for the life of the pool.
+type PoolStats struct {
+	AcquiredConns     int32
+	IdleConns         int32
+	ConstructingConns int32
+	TotalConns        int32
+	MaxConns          int32
+	AcquireCountTotal int64
+	// WaitedAcquireCountTotal counts the acquires that waited for a
+	// connection because none was idle.
+	WaitedAcquireCountTotal   int64
+	CanceledAcquireCountTotal int64
+	AcquireDurationTotal      time.Duration
+	WaitDurationTotal         time.Duration
+}
+
+func poolStats(pool *pgxpool.Pool) PoolStats {
+	stat := pool.Stat()
+	return PoolStats{
+		AcquiredConns:             stat.AcquiredConns(),
+		IdleConns:                 stat.IdleConns(),
+		ConstructingConns:         stat.ConstructingConns(),
+		TotalConns:                stat.TotalConns(),
+		MaxConns:                  stat.MaxConns(),
+		AcquireCountTotal:         stat.AcquireCount(),
+		WaitedAcquireCountTotal:   stat.EmptyAcquireCount(),
+		CanceledAcquireCountTotal: stat.CanceledAcquireCount(),
+		AcquireDurationTotal:      stat.AcquireDuration(),
+		WaitDurationTotal:         stat.EmptyAcquireWaitTime(),
+	}
+}
+
+// defaultPoolStatsInterval is used by ReportPoolStats when interval is not
+// positive.
+const defaultPoolStatsInterval = 15 * time.Second
+
+// ReportPoolStats passes the stats of pool to metrics every interval, 15s if
+// zero, until ctx is cancelled.
+func ReportPoolStats(ctx *context.Context, name string, pool *pgxpool.Pool, metrics Metrics, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultPoolStatsInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		metrics.ObservePool(name, poolStats(pool))
+		select {
+		case <-ctx.Context.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// WithQueryName returns a clone of ctx naming the queries run with it in
+// metrics and spans.
+func WithQueryName(ctx *context.Context, name string) *context.Context {
+	named := ctx.Clone()
+	named.Set(enums.DbQueryName, name)
+	return named
+}
+
+var nameComment = regexp.MustCompile(`^\s*--\s*name:\s*(\S+)`)
+
+// queryName names a query by, in order, the name set with WithQueryName, a
+// leading "-- name: <name>" comment as written by sqlc, or its first keyword,
+// e.g. "SELECT". Names must not be derived from the rest of the SQL, which
+// would give metrics an unbounded number of labels.
+func queryName(ctx stdcontext.Context, sql string) string {
+	if name, ok := ctx.Value(enums.DbQueryName).(string); ok && name != "" {
+		return name
+	}
+	if match := nameComment.FindStringSubmatch(sql); match != nil {
+		return match[1]
+	}
+	sql = strings.TrimSpace(sql)
+	for strings.HasPrefix(sql, "--") {
+		_, sql, _ = strings.Cut(sql, "\n")
+		sql = strings.TrimSpace(sql)
+	}
+	if i := strings.IndexFunc(sql, func(r rune) bool { return r == ' ' || r == '\n' || r == '\t' || r == '(' }); i >= 0 {
+		sql = sql[:i]
+	}
+	return strings.ToUpper(sql)
+}
@@ -0,0 +1,512 @@
+package postgres
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// migrationFile matches migration file names such as 0001_create_users.up.sql.
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// noTransactionDirective on the first line of a file runs its statements one
+// by one outside of a transaction, e.g. for CREATE INDEX CONCURRENTLY, which
+// Postgres rejects in the implicit transaction of a multi-statement query.
+const noTransactionDirective = "-- migrate:no-transaction"
+
+type MigrationConfig struct {
+	// Dir is the directory the migration files are read from when no fs.FS is
+	// given.
+	Dir string `json:"dir"`
+	// Table records the applied migrations, "schema_migrations" by default. It
+	// may be qualified with a schema.
+	Table string `json:"table"`
+	// LockId is the advisory lock held while migrating, derived from Table by
+	// default.
+	LockId int64 `json:"lock_id"`
+	// TargetVersion to migrate up or down to, the latest version if nil. 0
+	// reverts every migration.
+	TargetVersion *int64 `json:"target_version"`
+	// BaselineVersion marks the migrations up to it as applied without running
+	// them, for databases created before migrations were introduced. It only
+	// applies while no migration is recorded.
+	BaselineVersion int64 `json:"baseline_version"`
+	// DryRun logs the migrations that would run without changing the database.
+	DryRun bool `json:"dry_run"`
+}
+
+func (cfg MigrationConfig) withDefaults() MigrationConfig {
+	if cfg.Table == "" {
+		cfg.Table = "schema_migrations"
+	}
+	if cfg.LockId == 0 {
+		hash := fnv.New64a()
+		hash.Write([]byte("migrations:" + cfg.Table))
+		cfg.LockId = int64(hash.Sum64())
+	}
+	return cfg
+}
+
+// Migration is a pair of up and down SQL files sharing a version.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// Checksum of Up, recorded when the migration is applied.
+	Checksum string
+}
+
+// MigrationStatus reports whether a migration is applied.
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	Baseline  bool
+	AppliedAt time.Time
+}
+
+// appliedMigration is a row of the migrations table.
+type appliedMigration struct {
+	version   int64
+	checksum  string
+	baseline  bool
+	appliedAt time.Time
+}
+
+// LoadMigrations reads the migration files in the root of source, ordered by
+// version. Use fs.Sub for files in a subdirectory of an embed.FS.
+func LoadMigrations(source fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(source, ".")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read migrations")
+	}
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid migration version in %s", entry.Name()))
+		}
+		sql, err := fs.ReadFile(source, entry.Name())
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to read migration %s", entry.Name()))
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, errors.New(fmt.Sprintf("migration version %d is used by %s and %s", version, migration.Name, match[2]))
+		}
+		if match[3] == "up" {
+			migration.Up = string(sql)
+			checksum := sha256.Sum256(sql)
+			migration.Checksum = hex.EncodeToString(checksum[:])
+		} else {
+			migration.Down = string(sql)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, errors.New(fmt.Sprintf("migration %d_%s has no up file", migration.Version, migration.Name))
+		}
+		migrations = append(migrations, *migration)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
+
+// Migrator applies the migrations read from source to the database.
+type Migrator struct {
+	pool   *pgxpool.Pool
+	source fs.FS
+	cfg    MigrationConfig
+	logger *zap.Logger
+}
+
+// NewMigrator returns a Migrator reading migrations from source, or from
+// cfg.Dir if source is nil.
+func NewMigrator(pool *pgxpool.Pool, source fs.FS, cfg MigrationConfig, logger *zap.Logger) *Migrator {
+	cfg = cfg.withDefaults()
+	if source == nil {
+		source = os.DirFS(cfg.Dir)
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Migrator{
+		pool:   pool,
+		source: source,
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+// Migrate migrates the database up or down to the target version. It holds an
+// advisory lock throughout, so instances started together migrate one after
+// the other and the later ones find nothing left to do. Applied migrations
+// whose up file changed fail the run before anything is applied.
+func (m *Migrator) Migrate(ctx *context.Context) error {
+	migrations, err := LoadMigrations(m.source)
+	if err != nil {
+		return err
+	}
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := m.appliedIfExists(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 && m.cfg.BaselineVersion > 0 {
+			if applied, err = m.baseline(ctx, conn, migrations); err != nil {
+				return err
+			}
+		}
+		if err := m.verify(migrations, applied); err != nil {
+			return err
+		}
+
+		var target int64
+		switch {
+		case m.cfg.TargetVersion != nil:
+			target = *m.cfg.TargetVersion
+		case len(migrations) > 0:
+			target = migrations[len(migrations)-1].Version
+		}
+
+		// Revert the applied migrations above the target, newest first.
+		for i := len(migrations) - 1; i >= 0; i-- {
+			migration := migrations[i]
+			if _, ok := applied[migration.Version]; !ok || migration.Version <= target {
+				continue
+			}
+			if err := m.run(ctx, conn, migration, false); err != nil {
+				return err
+			}
+		}
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Version]; ok || migration.Version > target {
+				continue
+			}
+			if err := m.run(ctx, conn, migration, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Status lists the known migrations and whether they are applied. It only
+// reads the database, so none is applied until the migrations table exists.
+func (m *Migrator) Status(ctx *context.Context) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations(m.source)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := m.pool.Acquire(ctx.Context)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to acquire connection")
+	}
+	defer conn.Release()
+	applied, err := m.appliedIfExists(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		row, ok := applied[migration.Version]
+		statuses[i] = MigrationStatus{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			Baseline:  row.baseline,
+			AppliedAt: row.appliedAt,
+		}
+	}
+	return statuses, nil
+}
+
+func (m *Migrator) withLock(ctx *context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.pool.Acquire(ctx.Context)
+	if err != nil {
+		return errors.Wrap(err, "failed to acquire connection")
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx.Context, "SELECT pg_advisory_lock($1)", m.cfg.LockId); err != nil {
+		return errors.Wrap(err, "failed to take migration lock")
+	}
+	defer func() {
+		if _, err := conn.Exec(ctx.WithoutCancel().Context, "SELECT pg_advisory_unlock($1)", m.cfg.LockId); err != nil {
+			// The lock is released when the connection closes.
+			m.logger.Warn("Failed to release migration lock", zap.Error(err))
+			_ = conn.Conn().Close(ctx.WithoutCancel().Context)
+		}
+	}()
+
+	// A dry run changes nothing, so it finds no migration applied until the
+	// table exists.
+	if !m.cfg.DryRun {
+		if err := m.createTable(ctx, conn); err != nil {
+			return err
+		}
+	}
+	return fn(conn)
+}
+
+func (m *Migrator) table() string {
+	return pgx.Identifier(strings.Split(m.cfg.Table, ".")).Sanitize()
+}
+
+func (m *Migrator) createTable(ctx *context.Context, conn *pgxpool.Conn) error {
+	_, err := conn.Exec(ctx.Context, `CREATE TABLE IF NOT EXISTS `+m.table()+` (
+		version    BIGINT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		checksum   TEXT NOT NULL,
+		baseline   BOOLEAN NOT NULL DEFAULT false,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	return errors.Wrap(err, fmt.Sprintf("failed to create migrations table %s", m.cfg.Table))
+}
+
+// appliedIfExists returns the applied migrations, none if the migrations table
+// does not exist.
+func (m *Migrator) appliedIfExists(ctx *context.Context, conn *pgxpool.Conn) (map[int64]appliedMigration, error) {
+	var exists bool
+	if err := conn.QueryRow(ctx.Context, "SELECT to_regclass($1) IS NOT NULL", m.table()).Scan(&exists); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to look up migrations table %s", m.cfg.Table))
+	}
+	if !exists {
+		return map[int64]appliedMigration{}, nil
+	}
+	return m.applied(ctx, conn)
+}
+
+func (m *Migrator) applied(ctx *context.Context, conn *pgxpool.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.Query(ctx.Context, "SELECT version, checksum, baseline, applied_at FROM "+m.table())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read applied migrations")
+	}
+	defer rows.Close()
+	applied := map[int64]appliedMigration{}
+	for rows.Next() {
+		var row appliedMigration
+		if err := rows.Scan(&row.version, &row.checksum, &row.baseline, &row.appliedAt); err != nil {
+			return nil, errors.Wrap(err, "failed to read applied migrations")
+		}
+		applied[row.version] = row
+	}
+	return applied, errors.Wrap(rows.Err(), "failed to read applied migrations")
+}
+
+// baseline records the migrations up to the baseline version as applied.
+func (m *Migrator) baseline(ctx *context.Context, conn *pgxpool.Conn, migrations []Migration) (map[int64]appliedMigration, error) {
+	applied := map[int64]appliedMigration{}
+	for _, migration := range migrations {
+		if migration.Version > m.cfg.BaselineVersion {
+			break
+		}
+		m.logger.Info("Baselining migration",
+			zap.Int64("version", migration.Version),
+			zap.String("name", migration.Name),
+			zap.Bool("dryRun", m.cfg.DryRun),
+		)
+		if !m.cfg.DryRun {
+			_, err := conn.Exec(ctx.Context,
+				"INSERT INTO "+m.table()+" (version, name, checksum, baseline) VALUES ($1, $2, $3, true)",
+				migration.Version, migration.Name, migration.Checksum,
+			)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("failed to baseline migration %d", migration.Version))
+			}
+		}
+		applied[migration.Version] = appliedMigration{version: migration.Version, checksum: migration.Checksum, baseline: true}
+	}
+	return applied, nil
+}
+
+func (m *Migrator) verify(migrations []Migration, applied map[int64]appliedMigration) error {
+	known := map[int64]bool{}
+	for _, migration := range migrations {
+		known[migration.Version] = true
+		if row, ok := applied[migration.Version]; ok && row.checksum != migration.Checksum {
+			return errors.Wrap(ErrMigrationChecksum, fmt.Sprintf("migration %d_%s", migration.Version, migration.Name))
+		}
+	}
+	for version := range applied {
+		if !known[version] {
+			m.logger.Warn("Applied migration has no file", zap.Int64("version", version))
+		}
+	}
+	return nil
+}
+
+// run applies migration, or reverts it if up is false, and records it.
+func (m *Migrator) run(ctx *context.Context, conn *pgxpool.Conn, migration Migration, up bool) error {
+	sql, direction := migration.Up, "up"
+	if !up {
+		sql, direction = migration.Down, "down"
+		if sql == "" {
+			return errors.New(fmt.Sprintf("migration %d_%s has no down file", migration.Version, migration.Name))
+		}
+	}
+	logger := m.logger.With(
+		zap.Int64("version", migration.Version),
+		zap.String("name", migration.Name),
+		zap.String("direction", direction),
+	)
+	if m.cfg.DryRun {
+		logger.Info("Would run migration")
+		return nil
+	}
+
+	start := time.Now()
+	record := func(exec func(sql string, args ...any) error) error {
+		if up {
+			return exec("INSERT INTO "+m.table()+" (version, name, checksum) VALUES ($1, $2, $3)",
+				migration.Version, migration.Name, migration.Checksum)
+		}
+		return exec("DELETE FROM "+m.table()+" WHERE version = $1", migration.Version)
+	}
+
+	var err error
+	if strings.HasPrefix(strings.TrimSpace(sql), noTransactionDirective) {
+		exec := func(sql string, args ...any) error {
+			_, err := conn.Exec(ctx.Context, sql, args...)
+			return err
+		}
+		statements := splitStatements(sql)
+		for i, statement := range statements {
+			if err = exec(statement); err != nil {
+				// The statements before it stay applied.
+				err = errors.Wrap(err, fmt.Sprintf("statement %d of %d", i+1, len(statements)))
+				break
+			}
+		}
+		if err == nil {
+			err = record(exec)
+		}
+	} else {
+		err = pgx.BeginFunc(ctx.Context, conn, func(tx pgx.Tx) error {
+			exec := func(sql string, args ...any) error {
+				_, err := tx.Exec(ctx.Context, sql, args...)
+				return err
+			}
+			if err := exec(sql); err != nil {
+				return err
+			}
+			return record(exec)
+		})
+	}
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("migration %d_%s %s failed", migration.Version, migration.Name, direction))
+	}
+	logger.Info("Ran migration", zap.Duration("duration", time.Since(start)))
+	return nil
+}
+
+// dollarQuote matches the opening tag of a dollar-quoted string, e.g. $$ or
+// $body$.
+var dollarQuote = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// splitStatements splits sql on the semicolons ending its statements, skipping
+// those in quoted strings and identifiers, dollar-quoted bodies and comments.
+// Statements holding only comments and whitespace are dropped.
+func splitStatements(sql string) []string {
+	var statements []string
+	start, code := 0, false
+	flush := func(end int) {
+		if code {
+			statements = append(statements, strings.TrimSpace(sql[start:end]))
+		}
+		start, code = end+1, false
+	}
+	for i := 0; i < len(sql); i++ {
+		switch c := sql[i]; {
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(sql)
+			}
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			// Block comments nest.
+			depth := 0
+			for ; i < len(sql); i++ {
+				if strings.HasPrefix(sql[i:], "/*") {
+					depth++
+					i++
+				} else if strings.HasPrefix(sql[i:], "*/") {
+					depth--
+					i++
+					if depth == 0 {
+						break
+					}
+				}
+			}
+		case c == '\'' || c == '"':
+			// E'...' strings escape quotes with backslashes, the others by
+			// doubling them.
+			backslash := c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e')
+			code = true
+			for i++; i < len(sql); i++ {
+				if backslash && sql[i] == '\\' {
+					i++
+				} else if sql[i] == c {
+					if i+1 < len(sql) && sql[i+1] == c {
+						i++
+					} else {
+						break
+					}
+				}
+			}
+		case c == '$' && dollarQuote.MatchString(sql[i:]):
+			tag := dollarQuote.FindString(sql[i:])
+			code = true
+			if end := strings.Index(sql[i+len(tag):], tag); end >= 0 {
+				i += len(tag) + end + len(tag) - 1
+			} else {
+				i = len(sql)
+			}
+		case c == ';':
+			flush(i)
+		case !unicode.IsSpace(rune(c)):
+			code = true
+		}
+	}
+	flush(len(sql))
+	return statements
+}
+
+// WithMigrations makes Init migrate the database to the latest version, or
+// cfg.TargetVersion, before returning the pool. source may be nil to read the
+// files from cfg.Dir.
+func WithMigrations(source fs.FS, cfg MigrationConfig) InitOption {
+	return func(opts *initOptions) {
+		opts.onConnect = append(opts.onConnect, func(pool *pgxpool.Pool, logger *zap.Logger) error {
+			return NewMigrator(pool, source, cfg, logger).Migrate(context.NewContext())
+		})
+	}
+}
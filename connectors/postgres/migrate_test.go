@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"github.com/NitinD97/common-utils/connectors/postgres/pgtest"
+	"github.com/NitinD97/common-utils/context"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{
+			name: "directive and statements",
+			sql: `-- migrate:no-transaction
+CREATE INDEX CONCURRENTLY users_email ON users (email);
+CREATE INDEX CONCURRENTLY users_name ON users (name);
+`,
+			want: []string{
+				"-- migrate:no-transaction\nCREATE INDEX CONCURRENTLY users_email ON users (email)",
+				"CREATE INDEX CONCURRENTLY users_name ON users (name)",
+			},
+		},
+		{
+			name: "no trailing semicolon",
+			sql:  "SELECT 1; SELECT 2",
+			want: []string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			name: "quoted semicolons",
+			sql:  `INSERT INTO t VALUES ('a;b', 'it''s;', E'\';'); SELECT ";" FROM t`,
+			want: []string{`INSERT INTO t VALUES ('a;b', 'it''s;', E'\';')`, `SELECT ";" FROM t`},
+		},
+		{
+			name: "comments",
+			sql:  "SELECT 1; -- a; comment\n/* a /* nested; */ comment; */ SELECT 2; -- trailing;",
+			want: []string{"SELECT 1", "-- a; comment\n/* a /* nested; */ comment; */ SELECT 2"},
+		},
+		{
+			name: "dollar quotes",
+			sql: `CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;
+DO $$ BEGIN PERFORM 1; END $$;
+SELECT $1;`,
+			want: []string{
+				"CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql",
+				"DO $$ BEGIN PERFORM 1; END $$",
+				"SELECT $1",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := splitStatements(test.sql); !slices.Equal(got, test.want) {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMigrateDryRunChangesNothing(t *testing.T) {
+	pool := pgtest.NewPool(t)
+	ctx := context.NewContext()
+	source := fstest.MapFS{
+		"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id BIGINT PRIMARY KEY);")},
+		"0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+	}
+
+	if err := NewMigrator(pool, source, MigrationConfig{DryRun: true}, nil).Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"schema_migrations", "users"} {
+		var exists bool
+		if err := pool.QueryRow(ctx.Context, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+			t.Fatal(err)
+		}
+		if exists {
+			t.Errorf("dry run created %s", table)
+		}
+	}
+}
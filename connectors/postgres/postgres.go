@@ -3,95 +3,234 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"github.com/NitinD97/common-utils/configuration"
 	"github.com/NitinD97/common-utils/enums"
 	"github.com/NitinD97/common-utils/errors"
-	"github.com/google/uuid"
+	"github.com/NitinD97/common-utils/shutdown"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
+	"net"
 	"net/url"
+	"strconv"
+	"time"
 )
 
 var db *pgxpool.Pool
 
 type PgConfig struct {
-	Host               string `json:"host"`
-	Port               int    `json:"port"`
-	User               string `json:"user"`
-	Password           string `json:"password"`
-	DbName             string `json:"db_name"`
-	SslMode            string `json:"ssl_mode"`
-	PoolMinConnections int    `json:"pool_min_conns"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	DbName   string `json:"db_name"`
+	// SslMode is one of the libpq modes, use verify-full with SslRootCert to
+	// check the server certificate and host name.
+	SslMode string `json:"ssl_mode"`
+	// SslRootCert is the path of the CA certificate the server certificate is
+	// checked against. SslCert and SslKey are the paths of the client
+	// certificate and its key, for certificate authentication.
+	SslRootCert string `json:"ssl_root_cert"`
+	SslCert     string `json:"ssl_cert"`
+	SslKey      string `json:"ssl_key"`
+
+	PoolMinConnections int `json:"pool_min_conns"`
+	// PoolMaxConnections defaults to the greater of 4 and the number of CPUs.
+	PoolMaxConnections int `json:"pool_max_conns"`
+	// MaxConnLifetime is the age after which a connection is closed once
+	// released, 1h by default. Connections are recycled at slightly different
+	// ages so they are not all reconnected at once.
+	MaxConnLifetime time.Duration `json:"max_conn_lifetime"`
+	// MaxConnIdleTime is how long an idle connection is kept, 30m by default.
+	MaxConnIdleTime time.Duration `json:"max_conn_idle_time"`
+	// HealthCheckPeriod is the interval between checks of idle connections,
+	// 1m by default.
+	HealthCheckPeriod time.Duration `json:"health_check_period"`
+
+	// StatementTimeout aborts statements running longer, unlimited if zero.
+	StatementTimeout time.Duration `json:"statement_timeout"`
+	// ApplicationName is shown in pg_stat_activity, the service name from the
+	// configuration by default.
+	ApplicationName string `json:"application_name"`
+	// SearchPath is the schema search path, e.g. "app,public".
+	SearchPath string `json:"search_path"`
+
+	Tracer TracerConfig `json:"tracer"`
+}
+
+func (pgConfig PgConfig) validate() error {
+	if pgConfig.PoolMaxConnections > 0 && pgConfig.PoolMinConnections > pgConfig.PoolMaxConnections {
+		return errors.New(fmt.Sprintf("pool_min_conns %d exceeds pool_max_conns %d",
+			pgConfig.PoolMinConnections, pgConfig.PoolMaxConnections))
+	}
+	if (pgConfig.SslCert == "") != (pgConfig.SslKey == "") {
+		return errors.New("ssl_cert and ssl_key must be set together")
+	}
+	return nil
 }
 
-func Init(pgConfig PgConfig, logger *zap.Logger) (*pgxpool.Pool, error) {
-	dbURL := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
-		pgConfig.User,
-		url.PathEscape(pgConfig.Password),
-		pgConfig.Host,
-		pgConfig.Port,
-		pgConfig.DbName,
-		pgConfig.SslMode,
-	)
-
-	config, err := pgxpool.ParseConfig(dbURL)
+// url returns the connection URL without the password, so it can be logged.
+func (pgConfig PgConfig) url() string {
+	query := url.Values{}
+	if pgConfig.SslMode != "" {
+		query.Set("sslmode", pgConfig.SslMode)
+	}
+	if pgConfig.SslRootCert != "" {
+		query.Set("sslrootcert", pgConfig.SslRootCert)
+	}
+	if pgConfig.SslCert != "" {
+		query.Set("sslcert", pgConfig.SslCert)
+		query.Set("sslkey", pgConfig.SslKey)
+	}
+	dbURL := url.URL{
+		Scheme:   "postgres",
+		User:     url.User(pgConfig.User),
+		Host:     net.JoinHostPort(pgConfig.Host, strconv.Itoa(pgConfig.Port)),
+		Path:     "/" + pgConfig.DbName,
+		RawQuery: query.Encode(),
+	}
+	return dbURL.String()
+}
+
+func (pgConfig PgConfig) poolConfig() (*pgxpool.Config, error) {
+	if err := pgConfig.validate(); err != nil {
+		return nil, err
+	}
+	config, err := pgxpool.ParseConfig(pgConfig.url())
 	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("failed to parse database URL: %s", dbURL))
+		return nil, errors.Wrap(err, "failed to parse database config")
 	}
+	config.ConnConfig.Password = pgConfig.Password
 
 	config.MinConns = int32(pgConfig.PoolMinConnections)
 	config.MinIdleConns = int32(pgConfig.PoolMinConnections)
-	config.ConnConfig.Tracer = &CustomTracer{logger: logger}
+	if pgConfig.PoolMaxConnections > 0 {
+		config.MaxConns = int32(pgConfig.PoolMaxConnections)
+	}
+	if pgConfig.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = pgConfig.MaxConnLifetime
+	}
+	config.MaxConnLifetimeJitter = config.MaxConnLifetime / 10
+	if pgConfig.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = pgConfig.MaxConnIdleTime
+	}
+	if pgConfig.HealthCheckPeriod > 0 {
+		config.HealthCheckPeriod = pgConfig.HealthCheckPeriod
+	}
 
-	conn, err := pgxpool.NewWithConfig(context.Background(), config)
-	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("failed to connect to database: %s", dbURL))
+	params := config.ConnConfig.RuntimeParams
+	if pgConfig.StatementTimeout > 0 {
+		// Rounded up, as 0 would disable the timeout.
+		timeout := pgConfig.StatementTimeout.Milliseconds()
+		if time.Duration(timeout)*time.Millisecond < pgConfig.StatementTimeout {
+			timeout++
+		}
+		params["statement_timeout"] = strconv.FormatInt(timeout, 10)
+	}
+	applicationName := pgConfig.ApplicationName
+	if config := configuration.GetConfig(); applicationName == "" && config != nil {
+		applicationName = config.GetString(enums.ServiceName)
 	}
-	return conn, nil
+	if applicationName != "" {
+		params["application_name"] = applicationName
+	}
+	if pgConfig.SearchPath != "" {
+		params["search_path"] = pgConfig.SearchPath
+	}
+	return config, nil
 }
 
-func Disconnect() {
-	if db != nil {
-		db.Close()
+type InitOption func(*initOptions)
+
+type initOptions struct {
+	// onConnect runs once the pool is created, before Init returns it.
+	onConnect []func(pool *pgxpool.Pool, logger *zap.Logger) error
+	shutdown  *shutdown.Manager
+	tracer    pgx.QueryTracer
+	// tracerOptions apply to the tracer built from PgConfig.Tracer.
+	tracerOptions []TracerOption
+}
+
+// WithTracerOptions adds opts to the tracer configured by PgConfig.Tracer, e.g.
+// WithMetrics.
+func WithTracerOptions(opts ...TracerOption) InitOption {
+	return func(options *initOptions) {
+		options.tracerOptions = append(options.tracerOptions, opts...)
 	}
 }
 
-type CustomTracer struct {
-	logger *zap.Logger
+// WithTracer replaces the tracer configured by PgConfig.Tracer, e.g. with one
+// built by NewTracer and wrapped with extra instrumentation.
+func WithTracer(tracer pgx.QueryTracer) InitOption {
+	return func(options *initOptions) {
+		options.tracer = tracer
+	}
 }
 
-func (ct *CustomTracer) TraceQueryStart(
-	ctx context.Context,
-	_ *pgx.Conn,
-	data pgx.TraceQueryStartData,
-) context.Context {
-	dbQueryId := uuid.NewString()
-	ctx = context.WithValue(ctx, enums.DbQueryId, dbQueryId)
-	ct.logger.Info("Query started",
-		zap.String("sql", data.SQL),
-		zap.Any("args", data.Args),
-		zap.Any(enums.RequestId, ctx.Value(enums.RequestId)),
-		zap.String(enums.DbQueryId, dbQueryId),
-	)
-	return ctx
+// WithShutdown closes the pool gracefully when manager shuts down, see
+// Client.Close.
+func WithShutdown(manager *shutdown.Manager) InitOption {
+	return func(options *initOptions) {
+		options.shutdown = manager
+	}
+}
+
+// Init connects to the database and returns the pool, which Disconnect
+// closes. Prefer NewClient in new code.
+func Init(pgConfig PgConfig, logger *zap.Logger, opts ...InitOption) (*pgxpool.Pool, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	pool, options, err := connect(pgConfig, logger, opts)
+	if err != nil {
+		return nil, err
+	}
+	if options.shutdown != nil {
+		options.shutdown.Register("postgres", shutdown.CloserFunc(func(ctx context.Context) error {
+			return closePool(ctx, pool, logger)
+		}))
+	}
+	db = pool
+	return pool, nil
+}
+
+func connect(pgConfig PgConfig, logger *zap.Logger, opts []InitOption) (*pgxpool.Pool, *initOptions, error) {
+	options := &initOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	config, err := pgConfig.poolConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	config.ConnConfig.Tracer = options.tracer
+	if config.ConnConfig.Tracer == nil {
+		if config.ConnConfig.Tracer, err = NewTracer(logger, pgConfig.Tracer, options.tracerOptions...); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	conn, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, fmt.Sprintf("failed to connect to database: %s", pgConfig.url()))
+	}
+	for _, onConnect := range options.onConnect {
+		if err := onConnect(conn, logger); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	return conn, options, nil
 }
 
-func (ct *CustomTracer) TraceQueryEnd(
-	ctx context.Context,
-	_ *pgx.Conn,
-	data pgx.TraceQueryEndData,
-) {
-	if data.Err != nil {
-		ct.logger.Error("Query failed",
-			zap.Error(data.Err),
-			zap.Any(enums.RequestId, ctx.Value(enums.RequestId)),
-			zap.String(enums.DbQueryId, ctx.Value(enums.DbQueryId).(string)),
-		)
-	} else {
-		ct.logger.Info("Query succeeded",
-			zap.String("commandTag", data.CommandTag.String()),
-			zap.Any(enums.RequestId, ctx.Value(enums.RequestId)),
-			zap.String(enums.DbQueryId, ctx.Value(enums.DbQueryId).(string)),
-		)
+// Disconnect closes the pool returned by Init, waiting for the connections in
+// use to be released.
+func Disconnect() {
+	if db != nil {
+		db.Close()
 	}
 }
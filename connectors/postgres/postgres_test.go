@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPoolConfig(t *testing.T) {
+	tests := []struct {
+		name             string
+		pgConfig         PgConfig
+		lifetime         time.Duration
+		jitter           time.Duration
+		statementTimeout string
+	}{
+		{
+			name:     "default lifetime is jittered",
+			lifetime: time.Hour,
+			jitter:   6 * time.Minute,
+		},
+		{
+			name:             "configured lifetime and timeout",
+			pgConfig:         PgConfig{MaxConnLifetime: 10 * time.Minute, StatementTimeout: 5 * time.Second},
+			lifetime:         10 * time.Minute,
+			jitter:           time.Minute,
+			statementTimeout: "5000",
+		},
+		{
+			name:             "sub-millisecond timeout is rounded up",
+			pgConfig:         PgConfig{StatementTimeout: 1500 * time.Microsecond},
+			lifetime:         time.Hour,
+			jitter:           6 * time.Minute,
+			statementTimeout: "2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.pgConfig.Host = "localhost"
+			test.pgConfig.Port = 5432
+			config, err := test.pgConfig.poolConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.MaxConnLifetime != test.lifetime || config.MaxConnLifetimeJitter != test.jitter {
+				t.Errorf("lifetime %s with jitter %s, want %s with %s",
+					config.MaxConnLifetime, config.MaxConnLifetimeJitter, test.lifetime, test.jitter)
+			}
+			if timeout := config.ConnConfig.RuntimeParams["statement_timeout"]; timeout != test.statementTimeout {
+				t.Errorf("statement_timeout %q, want %q", timeout, test.statementTimeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package postgres
+
+import (
+	stdcontext "context"
+	"database/sql"
+	"fmt"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Querier runs queries. It is implemented by *pgxpool.Pool, *pgx.Conn and
+// pgx.Tx.
+type Querier interface {
+	Exec(ctx stdcontext.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx stdcontext.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx stdcontext.Context, sql string, args ...any) pgx.Row
+}
+
+// querier returns the transaction carried by ctx if there is one, so helpers
+// called inside WithTx join its transaction, and db otherwise.
+func querier(ctx *context.Context, db Querier) Querier {
+	if tx, ok := TxFromContext(ctx); ok {
+		return tx
+	}
+	return db
+}
+
+// QueryOne runs query and scans its first row into a T. Structs are scanned by
+// column name as in pgx.RowToStructByName, so every field needs a column; other
+// types are scanned from a single column. It returns ErrNotFound if the query
+// returns no row.
+func QueryOne[T any](ctx *context.Context, db Querier, query string, args ...any) (T, error) {
+	rows, err := querier(ctx, db).Query(ctx.Context, query, args...)
+	if err != nil {
+		var zero T
+		return zero, errors.Wrap(err, "query failed")
+	}
+	value, err := pgx.CollectOneRow(rows, rowTo[T]())
+	if errors.Is(err, pgx.ErrNoRows) {
+		return value, errors.Wrap(ErrNotFound, "query returned no row")
+	}
+	return value, errors.Wrap(err, "failed to scan row")
+}
+
+// QueryAll runs query and scans all its rows like QueryOne. It returns an empty
+// slice if the query returns no row.
+func QueryAll[T any](ctx *context.Context, db Querier, query string, args ...any) ([]T, error) {
+	rows, err := querier(ctx, db).Query(ctx.Context, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "query failed")
+	}
+	values, err := pgx.CollectRows(rows, rowTo[T]())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to scan rows")
+	}
+	return values, nil
+}
+
+// Exec runs a statement and returns the number of rows it affected.
+func Exec(ctx *context.Context, db Querier, statement string, args ...any) (int64, error) {
+	tag, err := querier(ctx, db).Exec(ctx.Context, statement, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "statement failed")
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Insert inserts value into table and returns the inserted row, with the
+// values set by the database such as generated ids and defaults.
+//
+// The columns are the fields of T with a db tag. A field tagged with the
+// default option, e.g. `db:"id,default"`, is left to the column default while
+// it holds its zero value. Fields tagged "-" are ignored.
+func Insert[T any](ctx *context.Context, db Querier, table string, value T) (T, error) {
+	columns, values, err := insertColumns(value)
+	if err != nil {
+		return value, err
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING %s",
+		quoteTable(table),
+		quoteColumns(columns),
+		placeholders(len(columns)),
+		quoteColumns(structColumnsOf[T]().names()),
+	)
+	inserted, err := insertRow[T](ctx, db, query, values)
+	return inserted, errors.Wrap(err, fmt.Sprintf("failed to insert into %s", table))
+}
+
+// Upsert inserts value into table like Insert or, if a row with the same
+// conflictColumns exists, updates the other columns of that row to value.
+// conflictColumns must match a unique index or constraint of table. It returns
+// the inserted or updated row.
+func Upsert[T any](ctx *context.Context, db Querier, table string, value T, conflictColumns ...string) (T, error) {
+	if len(conflictColumns) == 0 {
+		return value, errors.New(fmt.Sprintf("upsert into %s needs conflict columns", table))
+	}
+	columns, values, err := insertColumns(value)
+	if err != nil {
+		return value, err
+	}
+
+	conflicts := make(map[string]bool, len(conflictColumns))
+	for _, column := range conflictColumns {
+		conflicts[column] = true
+	}
+	var updates []string
+	for _, column := range columns {
+		if !conflicts[column] {
+			updates = append(updates, fmt.Sprintf("%[1]s = EXCLUDED.%[1]s", pgx.Identifier{column}.Sanitize()))
+		}
+	}
+	if len(updates) == 0 {
+		// DO NOTHING would not return the existing row.
+		column := pgx.Identifier{conflictColumns[0]}.Sanitize()
+		updates = append(updates, fmt.Sprintf("%[1]s = EXCLUDED.%[1]s", column))
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s RETURNING %s",
+		quoteTable(table),
+		quoteColumns(columns),
+		placeholders(len(columns)),
+		quoteColumns(conflictColumns),
+		strings.Join(updates, ", "),
+		quoteColumns(structColumnsOf[T]().names()),
+	)
+	upserted, err := insertRow[T](ctx, db, query, values)
+	return upserted, errors.Wrap(err, fmt.Sprintf("failed to upsert into %s", table))
+}
+
+func insertRow[T any](ctx *context.Context, db Querier, query string, values []any) (T, error) {
+	rows, err := querier(ctx, db).Query(ctx.Context, query, values...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	// The returned columns are the tagged fields only, untagged fields are
+	// left unset.
+	return pgx.CollectOneRow(rows, pgx.RowToStructByNameLax[T])
+}
+
+var scannerType = reflect.TypeFor[sql.Scanner]()
+
+// rowTo scans structs by name, except for structs scanned as a single value
+// such as time.Time and the pgtype types.
+func rowTo[T any]() pgx.RowToFunc[T] {
+	t := reflect.TypeFor[T]()
+	if t.Kind() == reflect.Struct && t != reflect.TypeFor[time.Time]() && !reflect.PointerTo(t).Implements(scannerType) {
+		return pgx.RowToStructByName[T]
+	}
+	return pgx.RowTo[T]
+}
+
+// structColumn is a field of a struct with a db tag.
+type structColumn struct {
+	name string
+	// index is the path of the field, through embedded structs.
+	index []int
+	// useDefault leaves the column to its default when the field is zero.
+	useDefault bool
+}
+
+type structColumns []structColumn
+
+func (columns structColumns) names() []string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.name
+	}
+	return names
+}
+
+var structColumnsCache sync.Map
+
+func structColumnsOf[T any]() structColumns {
+	t := reflect.TypeFor[T]()
+	if columns, ok := structColumnsCache.Load(t); ok {
+		return columns.(structColumns)
+	}
+	var columns structColumns
+	if t.Kind() == reflect.Struct {
+		columns = appendStructColumns(columns, t, nil)
+	}
+	structColumnsCache.Store(t, columns)
+	return columns
+}
+
+func appendStructColumns(columns structColumns, t reflect.Type, index []int) structColumns {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		fieldIndex := append(append([]int(nil), index...), i)
+		tag, tagged := field.Tag.Lookup("db")
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && !tagged {
+			columns = appendStructColumns(columns, field.Type, fieldIndex)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if !tagged || name == "" || name == "-" {
+			continue
+		}
+		columns = append(columns, structColumn{
+			name:       name,
+			index:      fieldIndex,
+			useDefault: options == "default",
+		})
+	}
+	return columns
+}
+
+// insertColumns returns the columns of value to insert and their values.
+func insertColumns[T any](value T) ([]string, []any, error) {
+	columns := structColumnsOf[T]()
+	if len(columns) == 0 {
+		return nil, nil, errors.New(fmt.Sprintf("%T has no fields with a db tag", value))
+	}
+	v := reflect.ValueOf(value)
+	names := make([]string, 0, len(columns))
+	values := make([]any, 0, len(columns))
+	for _, column := range columns {
+		field := v.FieldByIndex(column.index)
+		if column.useDefault && field.IsZero() {
+			continue
+		}
+		names = append(names, column.name)
+		values = append(values, field.Interface())
+	}
+	if len(names) == 0 {
+		return nil, nil, errors.New(fmt.Sprintf("all fields of %T are left to their defaults", value))
+	}
+	return names, values, nil
+}
+
+// quoteTable quotes a table name, optionally qualified by its schema.
+func quoteTable(table string) string {
+	return pgx.Identifier(strings.Split(table, ".")).Sanitize()
+}
+
+func quoteColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = pgx.Identifier{column}.Sanitize()
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func placeholders(n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return strings.Join(params, ", ")
+}
@@ -0,0 +1,22 @@
+package postgres
+
+import (
+	"fmt"
+	"github.com/NitinD97/common-utils/connectors/postgres/pgtest"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/errors"
+	"strings"
+	"testing"
+)
+
+func TestQueryOneNotFound(t *testing.T) {
+	pool := pgtest.NewPool(t)
+
+	_, err := QueryOne[int](context.NewContext(), pool, "SELECT 1 WHERE false")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if trace := fmt.Sprintf("%+v", err); !strings.Contains(trace, "query.go") {
+		t.Errorf("expected a stack trace through QueryOne, got %s", trace)
+	}
+}
@@ -0,0 +1,329 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"math/rand/v2"
+	"regexp"
+	"time"
+)
+
+const (
+	// ArgsNone leaves query arguments out of the logs.
+	ArgsNone = "none"
+	// ArgsTypes logs the type of each argument but not its value.
+	ArgsTypes = "types"
+	// ArgsValues logs argument values, with strings matching MaskPatterns
+	// masked.
+	ArgsValues = "values"
+)
+
+// maxArgLength is the length strings are truncated to in logs.
+const maxArgLength = 64
+
+type TracerConfig struct {
+	// Level is the level successful queries are logged at, "debug" by
+	// default. Failed queries are logged at error.
+	Level string `json:"level"`
+	// SlowQueryThreshold logs queries running longer at warn whatever Level
+	// and SampleRate, disabled if zero.
+	SlowQueryThreshold time.Duration `json:"slow_query_threshold"`
+	// Args is ArgsNone (default), ArgsTypes or ArgsValues.
+	Args string `json:"args"`
+	// MaskPatterns are regular expressions, e.g. matching emails or card
+	// numbers. With ArgsValues, string arguments matching one are logged as
+	// "***".
+	MaskPatterns []string `json:"mask_patterns"`
+	// SampleRate is the fraction of successful queries logged, 1 by default.
+	// Failed and slow queries are always logged.
+	SampleRate float64 `json:"sample_rate"`
+}
+
+func (cfg TracerConfig) withDefaults() TracerConfig {
+	if cfg.Level == "" {
+		cfg.Level = "debug"
+	}
+	if cfg.Args == "" {
+		cfg.Args = ArgsNone
+	}
+	if cfg.SampleRate <= 0 || cfg.SampleRate > 1 {
+		cfg.SampleRate = 1
+	}
+	return cfg
+}
+
+// CustomTracer logs the queries, batches, copies, prepares and connects of a
+// pool with their duration and the request ID of their context. Queries,
+// batches and copies are also recorded as OpenTelemetry spans and in Metrics.
+type CustomTracer struct {
+	logger       *zap.Logger
+	cfg          TracerConfig
+	level        zapcore.Level
+	maskPatterns []*regexp.Regexp
+	metrics      Metrics
+	spans        trace.Tracer
+}
+
+type TracerOption func(*CustomTracer)
+
+// WithMetrics records query durations and errors in metrics.
+func WithMetrics(metrics Metrics) TracerOption {
+	return func(ct *CustomTracer) {
+		ct.metrics = metrics
+	}
+}
+
+// WithTracerProvider creates spans with provider instead of the global one
+// set with otel.SetTracerProvider.
+func WithTracerProvider(provider trace.TracerProvider) TracerOption {
+	return func(ct *CustomTracer) {
+		ct.spans = provider.Tracer(instrumentationName)
+	}
+}
+
+const instrumentationName = "github.com/NitinD97/common-utils/connectors/postgres"
+
+// NewTracer returns a tracer logging through logger. Init builds one from
+// PgConfig.Tracer, with opts given through WithTracerOptions.
+func NewTracer(logger *zap.Logger, cfg TracerConfig, opts ...TracerOption) (*CustomTracer, error) {
+	cfg = cfg.withDefaults()
+	level, err := zapcore.ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid tracer level")
+	}
+	switch cfg.Args {
+	case ArgsNone, ArgsTypes, ArgsValues:
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown tracer args mode %q", cfg.Args))
+	}
+	tracer := &CustomTracer{
+		logger: logger,
+		cfg:    cfg,
+		level:  level,
+		spans:  otel.Tracer(instrumentationName),
+	}
+	for _, opt := range opts {
+		opt(tracer)
+	}
+	for _, pattern := range cfg.MaskPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid tracer mask pattern %q", pattern))
+		}
+		tracer.maskPatterns = append(tracer.maskPatterns, re)
+	}
+	return tracer, nil
+}
+
+type traceKey struct{}
+
+// queryTrace is what a start event passes to the matching end event.
+type queryTrace struct {
+	id    string
+	name  string
+	start time.Time
+	sql   string
+	args  []any
+	span  trace.Span
+}
+
+// start traces an event. Events with a name are measured and get a span, the
+// others are only logged.
+func (ct *CustomTracer) start(ctx context.Context, name string, sql string, args []any) context.Context {
+	t := &queryTrace{
+		id:    uuid.NewString(),
+		name:  name,
+		start: time.Now(),
+		sql:   sql,
+		args:  args,
+	}
+	if name != "" {
+		// The span is a child of the span of the request, if its context
+		// carries one.
+		ctx, t.span = ct.spans.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "postgresql"),
+				attribute.String("db.operation.name", name),
+				attribute.String("db.query.text", sql),
+			),
+		)
+	}
+	ctx = context.WithValue(ctx, enums.DbQueryId, t.id)
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// traceFrom returns the trace started for ctx, or an empty one if the start
+// event was not seen.
+func traceFrom(ctx context.Context) *queryTrace {
+	if t, ok := ctx.Value(traceKey{}).(*queryTrace); ok {
+		return t
+	}
+	return &queryTrace{start: time.Now()}
+}
+
+// end records the outcome of the traced event in metrics and its span.
+func (ct *CustomTracer) end(t *queryTrace, err error) time.Duration {
+	duration := time.Since(t.start)
+	if t.name == "" {
+		return duration
+	}
+	if ct.metrics != nil {
+		ct.metrics.ObserveQuery(t.name, duration)
+		if err != nil {
+			ct.metrics.CountError(t.name, sqlState(err))
+		}
+	}
+	if t.span != nil {
+		if err != nil {
+			if state := sqlState(err); state != "" {
+				t.span.SetAttributes(attribute.String("db.response.status_code", state))
+			}
+			t.span.RecordError(err)
+			t.span.SetStatus(codes.Error, err.Error())
+		}
+		t.span.End()
+	}
+	return duration
+}
+
+func (ct *CustomTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return ct.start(ctx, queryName(ctx, data.SQL), data.SQL, data.Args)
+}
+
+func (ct *CustomTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	t := traceFrom(ctx)
+	ct.log(ctx, "Query", t, ct.end(t, data.Err), data.Err,
+		zap.String("sql", t.sql),
+		ct.argsField(t.args),
+		zap.String("commandTag", data.CommandTag.String()),
+	)
+}
+
+func (ct *CustomTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	ctx = ct.start(ctx, "batch", "", nil)
+	traceFrom(ctx).span.SetAttributes(attribute.Int("db.operation.batch.size", data.Batch.Len()))
+	return ctx
+}
+
+// TraceBatchQuery logs each query of a batch. Their individual durations are
+// unknown, so the time since the batch started is logged. A failed query is
+// counted once, as the error of the batch, by TraceBatchEnd.
+func (ct *CustomTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	t := traceFrom(ctx)
+	ct.log(ctx, "Batch query", t, time.Since(t.start), data.Err,
+		zap.String("sql", data.SQL),
+		ct.argsField(data.Args),
+		zap.String("commandTag", data.CommandTag.String()),
+	)
+}
+
+func (ct *CustomTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	t := traceFrom(ctx)
+	ct.log(ctx, "Batch", t, ct.end(t, data.Err), data.Err)
+}
+
+func (ct *CustomTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	return ct.start(ctx, "copy "+data.TableName.Sanitize(), "COPY "+data.TableName.Sanitize(), nil)
+}
+
+func (ct *CustomTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	t := traceFrom(ctx)
+	ct.log(ctx, "Copy", t, ct.end(t, data.Err), data.Err,
+		zap.String("sql", t.sql),
+		zap.Int64("rows", data.CommandTag.RowsAffected()),
+	)
+}
+
+func (ct *CustomTracer) TracePrepareStart(ctx context.Context, _ *pgx.Conn, data pgx.TracePrepareStartData) context.Context {
+	return ct.start(ctx, "", data.SQL, nil)
+}
+
+func (ct *CustomTracer) TracePrepareEnd(ctx context.Context, _ *pgx.Conn, data pgx.TracePrepareEndData) {
+	t := traceFrom(ctx)
+	ct.log(ctx, "Prepare", t, ct.end(t, data.Err), data.Err,
+		zap.String("sql", t.sql),
+		zap.Bool("alreadyPrepared", data.AlreadyPrepared),
+	)
+}
+
+func (ct *CustomTracer) TraceConnectStart(ctx context.Context, data pgx.TraceConnectStartData) context.Context {
+	return ct.start(ctx, "", "", nil)
+}
+
+func (ct *CustomTracer) TraceConnectEnd(ctx context.Context, data pgx.TraceConnectEndData) {
+	t := traceFrom(ctx)
+	fields := []zap.Field{}
+	if data.Conn != nil {
+		config := data.Conn.Config()
+		fields = append(fields, zap.String("host", config.Host), zap.Uint16("port", config.Port))
+	}
+	ct.log(ctx, "Connect", t, ct.end(t, data.Err), data.Err, fields...)
+}
+
+// log writes "<event> failed" at Error, "<event> is slow" at Warn or
+// "<event> succeeded" at the configured level if sampled.
+func (ct *CustomTracer) log(ctx context.Context, event string, t *queryTrace, duration time.Duration, err error, fields ...zap.Field) {
+	fields = append(fields,
+		zap.Duration("duration", duration),
+		zap.Any(enums.RequestId, ctx.Value(enums.RequestId)),
+		zap.String(enums.DbQueryId, t.id),
+	)
+	switch {
+	case err != nil:
+		ct.logger.Error(event+" failed", append(fields, zap.Error(err))...)
+	case ct.cfg.SlowQueryThreshold > 0 && duration > ct.cfg.SlowQueryThreshold:
+		ct.logger.Warn(event+" is slow", fields...)
+	case ct.cfg.SampleRate < 1 && rand.Float64() >= ct.cfg.SampleRate:
+	default:
+		if ce := ct.logger.Check(ct.level, event+" succeeded"); ce != nil {
+			ce.Write(fields...)
+		}
+	}
+}
+
+func (ct *CustomTracer) argsField(args []any) zap.Field {
+	switch ct.cfg.Args {
+	case ArgsTypes:
+		types := make([]string, len(args))
+		for i, arg := range args {
+			types[i] = fmt.Sprintf("%T", arg)
+		}
+		return zap.Strings("argTypes", types)
+	case ArgsValues:
+		values := make([]any, len(args))
+		for i, arg := range args {
+			values[i] = ct.mask(arg)
+		}
+		return zap.Any("args", values)
+	}
+	return zap.Skip()
+}
+
+func (ct *CustomTracer) mask(arg any) any {
+	switch value := arg.(type) {
+	case string:
+		for _, re := range ct.maskPatterns {
+			if re.MatchString(value) {
+				return "***"
+			}
+		}
+		if len(value) > maxArgLength {
+			return value[:maxArgLength] + "..."
+		}
+		return value
+	case []byte:
+		return fmt.Sprintf("<%d bytes>", len(value))
+	}
+	return arg
+}
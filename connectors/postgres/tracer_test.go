@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// countingMetrics counts the errors passed to CountError.
+type countingMetrics struct {
+	errors map[string]int
+}
+
+func (m *countingMetrics) ObserveQuery(string, time.Duration) {}
+
+func (m *countingMetrics) CountError(name string, sqlState string) {
+	m.errors[name+" "+sqlState]++
+}
+
+func (m *countingMetrics) ObservePool(string, PoolStats) {}
+
+func TestBatchErrorIsCountedOnce(t *testing.T) {
+	metrics := &countingMetrics{errors: map[string]int{}}
+	tracer, err := NewTracer(zap.NewNop(), TracerConfig{}, WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := &pgx.Batch{}
+	batch.Queue("INSERT INTO users (email) VALUES ($1)", "a@example.com")
+	failure := &pgconn.PgError{Code: "23505"}
+
+	ctx := tracer.TraceBatchStart(context.Background(), nil, pgx.TraceBatchStartData{Batch: batch})
+	tracer.TraceBatchQuery(ctx, nil, pgx.TraceBatchQueryData{SQL: "INSERT INTO users (email) VALUES ($1)", Err: failure})
+	tracer.TraceBatchEnd(ctx, nil, pgx.TraceBatchEndData{Err: failure})
+
+	if len(metrics.errors) != 1 || metrics.errors["batch 23505"] != 1 {
+		t.Fatalf("counted errors %v, want one batch error", metrics.errors)
+	}
+}
@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"fmt"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"math/rand/v2"
+	"time"
+)
+
+type TxOptions struct {
+	// IsoLevel defaults to the isolation level configured on the server.
+	IsoLevel pgx.TxIsoLevel `json:"iso_level"`
+	ReadOnly bool           `json:"read_only"`
+	// MaxAttempts bounds how often a transaction failing with a serialization
+	// failure or a deadlock is run, 5 by default.
+	MaxAttempts int `json:"max_attempts"`
+	// BackoffMin and BackoffMax bound the randomized exponential backoff
+	// between attempts, 10ms and 1s by default.
+	BackoffMin time.Duration `json:"backoff_min"`
+	BackoffMax time.Duration `json:"backoff_max"`
+}
+
+func (opts TxOptions) withDefaults() TxOptions {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.BackoffMin <= 0 {
+		opts.BackoffMin = 10 * time.Millisecond
+	}
+	if opts.BackoffMax <= 0 {
+		opts.BackoffMax = time.Second
+	}
+	return opts
+}
+
+func (opts TxOptions) pgx() pgx.TxOptions {
+	txOptions := pgx.TxOptions{IsoLevel: opts.IsoLevel}
+	if opts.ReadOnly {
+		txOptions.AccessMode = pgx.ReadOnly
+	}
+	return txOptions
+}
+
+// TxFunc runs in a transaction. ctx carries tx, so helpers called with it
+// join the transaction through TxFromContext.
+type TxFunc func(ctx *context.Context, tx pgx.Tx) error
+
+// TxFromContext returns the transaction carried by ctx, if any.
+func TxFromContext(ctx *context.Context) (pgx.Tx, bool) {
+	tx, ok := ctx.Get(enums.DbTx).(pgx.Tx)
+	return tx, ok
+}
+
+// WithTx runs fn in a transaction, committed if fn returns nil and rolled back
+// if it returns an error or panics. Transactions failing with a serialization
+// failure (40001) or a deadlock (40P01) are retried with backoff, so fn must be
+// safe to run more than once.
+//
+// If ctx already carries a transaction, fn runs in a savepoint of it instead
+// and opts are ignored. Failures roll back to the savepoint only, and retries
+// are left to the outermost WithTx since the whole transaction is aborted.
+func WithTx(ctx *context.Context, pool *pgxpool.Pool, opts TxOptions, fn TxFunc) error {
+	if parent, ok := TxFromContext(ctx); ok {
+		return runTx(ctx, fn, func() (pgx.Tx, error) {
+			return parent.Begin(ctx.Context)
+		})
+	}
+
+	opts = opts.withDefaults()
+	backoff := opts.BackoffMin
+	for attempt := 1; ; attempt++ {
+		err := runTx(ctx, fn, func() (pgx.Tx, error) {
+			return pool.BeginTx(ctx.Context, opts.pgx())
+		})
+		if !retryable(err) || attempt >= opts.MaxAttempts {
+			return err
+		}
+		select {
+		case <-time.After(rand.N(backoff) + 1):
+		case <-ctx.Context.Done():
+			return errors.Wrap(err, fmt.Sprintf("transaction not retried after %d attempts", attempt))
+		}
+		backoff = min(backoff*2, opts.BackoffMax)
+	}
+}
+
+func runTx(ctx *context.Context, fn TxFunc, begin func() (pgx.Tx, error)) (err error) {
+	tx, err := begin()
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback(ctx.WithoutCancel().Context)
+			panic(p)
+		}
+	}()
+
+	txCtx := ctx.Clone()
+	txCtx.Set(enums.DbTx, tx)
+	if err := fn(txCtx, tx); err != nil {
+		// Roll back even if ctx was cancelled, so the connection is released
+		// in a usable state.
+		if rollbackErr := tx.Rollback(ctx.WithoutCancel().Context); rollbackErr != nil {
+			return errors.Wrap(err, fmt.Sprintf("rollback failed: %v", rollbackErr))
+		}
+		return err
+	}
+	return errors.Wrap(tx.Commit(ctx.Context), "failed to commit transaction")
+}
+
+func retryable(err error) bool {
+	switch sqlState(err) {
+	case sqlStateSerializationFailure, sqlStateDeadlockDetected:
+		return true
+	}
+	return false
+}
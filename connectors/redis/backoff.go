@@ -0,0 +1,42 @@
+package redis
+
+import (
+	"github.com/NitinD97/common-utils/context"
+	"time"
+)
+
+// backoff produces exponentially growing delays between min and max.
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func newBackoff(min, max time.Duration) *backoff {
+	return &backoff{min: min, max: max}
+}
+
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.min
+	} else {
+		b.current = min(b.current*2, b.max)
+	}
+	return b.current
+}
+
+func (b *backoff) reset() {
+	b.current = 0
+}
+
+// sleepContext waits for d and reports whether ctx is still alive afterwards.
+func sleepContext(ctx *context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Context.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
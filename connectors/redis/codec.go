@@ -0,0 +1,259 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/NitinD97/common-utils/encryption"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionZstd   = "zstd"
+	CompressionSnappy = "snappy"
+)
+
+// With codecs configured, values are wrapped in one envelope per codec: a
+// marker byte, the ID of the codec and its output, or codecIdNone and the
+// value as it is when the codec left it unchanged. Every layer is therefore an
+// envelope, and a value not starting with the marker is one written before
+// codecs were enabled.
+const envelopeMarker byte = 0x00
+
+const (
+	codecIdNone   byte = 'n'
+	codecIdZstd   byte = 'z'
+	codecIdSnappy byte = 's'
+	codecIdAESGCM byte = 'e'
+)
+
+// Codec transforms cached values on their way to and from Redis. Codecs given
+// to WithCodecs are applied in order when writing and undone in reverse order
+// when reading.
+type Codec interface {
+	// ID identifies the codec in the envelope of encoded values. 'n' is
+	// reserved for values a codec left unchanged.
+	ID() byte
+	// Encode returns the encoded value, or false to store value as it is.
+	Encode(value []byte) ([]byte, bool, error)
+	Decode(value []byte) ([]byte, error)
+}
+
+// keyBoundCodec is implemented by codecs binding their output to the key it
+// is stored under, so it cannot be copied to another key.
+type keyBoundCodec interface {
+	encodeFor(key string, value []byte) ([]byte, bool, error)
+	decodeFor(key string, value []byte) ([]byte, error)
+}
+
+// WithCodecs sets the codecs values are encoded with, typically a compression
+// codec followed by an encryption codec.
+func WithCodecs(codecs ...Codec) CacheOption {
+	return func(c *Cache) {
+		c.codecs = codecs
+	}
+}
+
+// encode encodes value to be stored under key.
+func (cache *Cache) encode(key string, value []byte) ([]byte, error) {
+	for _, codec := range cache.codecs {
+		var encoded []byte
+		var ok bool
+		var err error
+		if bound, isBound := codec.(keyBoundCodec); isBound {
+			encoded, ok, err = bound.encodeFor(key, value)
+		} else {
+			encoded, ok, err = codec.Encode(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		id := codec.ID()
+		if !ok {
+			id, encoded = codecIdNone, value
+		}
+		value = append([]byte{envelopeMarker, id}, encoded...)
+	}
+	return value, nil
+}
+
+// decode decodes value read from key.
+func (cache *Cache) decode(key string, value []byte) ([]byte, error) {
+	if len(cache.codecs) == 0 {
+		return value, nil
+	}
+	if len(value) < 2 || value[0] != envelopeMarker {
+		// Written before codecs were enabled.
+		return value, nil
+	}
+	// Layers are undone in reverse order, and only as many as there are
+	// codecs, so a decoded value starting with the marker is left alone.
+	for i := len(cache.codecs) - 1; i >= 0; i-- {
+		codec := cache.codecs[i]
+		if len(value) < 2 || value[0] != envelopeMarker {
+			return nil, errors.Wrap(ErrUnknownCodec, fmt.Sprintf("missing envelope of codec %q", codec.ID()))
+		}
+		switch value[1] {
+		case codecIdNone:
+			value = value[2:]
+		case codec.ID():
+			var decoded []byte
+			var err error
+			if bound, isBound := codec.(keyBoundCodec); isBound {
+				decoded, err = bound.decodeFor(key, value[2:])
+			} else {
+				decoded, err = codec.Decode(value[2:])
+			}
+			if err != nil {
+				return nil, err
+			}
+			value = decoded
+		default:
+			return nil, errors.Wrap(ErrUnknownCodec, fmt.Sprintf("codec %q", value[1]))
+		}
+	}
+	return value, nil
+}
+
+// NewCompressionCodec returns a CompressionZstd or CompressionSnappy codec that
+// compresses values of at least threshold bytes.
+func NewCompressionCodec(algorithm string, threshold int) (Codec, error) {
+	switch algorithm {
+	case CompressionZstd:
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		decoder, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		return &zstdCodec{threshold: threshold, encoder: encoder, decoder: decoder}, nil
+	case CompressionSnappy:
+		return &snappyCodec{threshold: threshold}, nil
+	default:
+		return nil, errors.Wrap(ErrInvalidConfig, fmt.Sprintf("unknown compression %q", algorithm))
+	}
+}
+
+type zstdCodec struct {
+	threshold int
+	encoder   *zstd.Encoder
+	decoder   *zstd.Decoder
+}
+
+func (c *zstdCodec) ID() byte {
+	return codecIdZstd
+}
+
+func (c *zstdCodec) Encode(value []byte) ([]byte, bool, error) {
+	if len(value) < c.threshold {
+		return value, false, nil
+	}
+	return c.encoder.EncodeAll(value, nil), true, nil
+}
+
+func (c *zstdCodec) Decode(value []byte) ([]byte, error) {
+	decoded, err := c.decoder.DecodeAll(value, nil)
+	return decoded, errors.Wrap(err, "failed to decompress zstd value")
+}
+
+type snappyCodec struct {
+	threshold int
+}
+
+func (c *snappyCodec) ID() byte {
+	return codecIdSnappy
+}
+
+func (c *snappyCodec) Encode(value []byte) ([]byte, bool, error) {
+	if len(value) < c.threshold {
+		return value, false, nil
+	}
+	return snappy.Encode(nil, value), true, nil
+}
+
+func (c *snappyCodec) Decode(value []byte) ([]byte, error) {
+	decoded, err := snappy.Decode(nil, value)
+	return decoded, errors.Wrap(err, "failed to decompress snappy value")
+}
+
+// aesGCMCodec encrypts values with the key keyId and decrypts with whichever
+// key the value was encrypted with, so keys can be rotated without flushing
+// the cache. Its output is the length of the key ID, the key ID and the
+// AES-GCM ciphertext, authenticated together with the key ID and, when stored
+// by a Cache, the Redis key.
+type aesGCMCodec struct {
+	keyId string
+	keys  map[string][]byte
+}
+
+// NewAESGCMCodec returns an encryption codec. keys maps key IDs to base64 AES
+// keys as generated by encryption.KeyGeneratorFactory(encryption.EncryptionAlgorithmAES, 256),
+// and keyId selects the key new values are encrypted with.
+func NewAESGCMCodec(keyId string, keys map[string]string) (Codec, error) {
+	if len(keyId) == 0 || len(keyId) > 255 {
+		return nil, errors.Wrap(ErrInvalidConfig, "encryption key id must be 1 to 255 bytes long")
+	}
+	parsed := make(map[string][]byte, len(keys))
+	for id, key := range keys {
+		bytes, err := encryption.ParseAESKey(key)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("invalid encryption key %s", id))
+		}
+		parsed[id] = bytes
+	}
+	if _, ok := parsed[keyId]; !ok {
+		return nil, errors.Wrap(ErrInvalidConfig, fmt.Sprintf("encryption key %s not found", keyId))
+	}
+	return &aesGCMCodec{keyId: keyId, keys: parsed}, nil
+}
+
+func (c *aesGCMCodec) ID() byte {
+	return codecIdAESGCM
+}
+
+func (c *aesGCMCodec) Encode(value []byte) ([]byte, bool, error) {
+	return c.encodeFor("", value)
+}
+
+func (c *aesGCMCodec) Decode(value []byte) ([]byte, error) {
+	return c.decodeFor("", value)
+}
+
+// additionalData authenticates the key ID and the Redis key, if any.
+func additionalData(keyId string, key string) []byte {
+	if key == "" {
+		return []byte(keyId)
+	}
+	return []byte(keyId + "\x00" + key)
+}
+
+func (c *aesGCMCodec) encodeFor(key string, value []byte) ([]byte, bool, error) {
+	ciphertext, err := encryption.AESGCMEncrypt(c.keys[c.keyId], value, additionalData(c.keyId, key))
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to encrypt value")
+	}
+	encoded := make([]byte, 0, 1+len(c.keyId)+len(ciphertext))
+	encoded = append(encoded, byte(len(c.keyId)))
+	encoded = append(encoded, c.keyId...)
+	return append(encoded, ciphertext...), true, nil
+}
+
+// decodeFor also accepts values encrypted before they were bound to their key,
+// which can still be copied to other keys until they expire.
+func (c *aesGCMCodec) decodeFor(key string, value []byte) ([]byte, error) {
+	if len(value) < 1 || len(value) < 1+int(value[0]) {
+		return nil, errors.New("encrypted value is truncated")
+	}
+	keyId := string(value[1 : 1+int(value[0])])
+	aesKey, ok := c.keys[keyId]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("encryption key %s not found", keyId))
+	}
+	decrypted, err := encryption.AESGCMDecrypt(aesKey, value[1+len(keyId):], additionalData(keyId, key))
+	if err != nil && key != "" {
+		decrypted, err = encryption.AESGCMDecrypt(aesKey, value[1+len(keyId):], additionalData(keyId, ""))
+	}
+	return decrypted, errors.Wrap(err, "failed to decrypt value")
+}
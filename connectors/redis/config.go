@@ -1,9 +1,181 @@
 package redis
 
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/NitinD97/common-utils/errors"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
 type Config struct {
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	Password string `json:"password"`
-	Db       int    `json:"db"`
-	PoolSize int    `json:"pool_size"`
+	// Driver selects the client library, DriverGoRedis (default) or DriverRueidis.
+	Driver string `json:"driver"`
+	// Mode is ModeStandalone (default), ModeSentinel or ModeCluster.
+	Mode string `json:"mode"`
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	// Addrs lists the sentinels in sentinel mode and the seed nodes in
+	// cluster mode, as host:port.
+	Addrs []string `json:"addrs"`
+	// MasterName is the master set monitored by the sentinels.
+	MasterName       string        `json:"master_name"`
+	SentinelUsername string        `json:"sentinel_username"`
+	SentinelPassword string        `json:"sentinel_password"`
+	Username         string        `json:"username"`
+	Password         string        `json:"password"`
+	Db               int           `json:"db"`
+	PoolSize         int           `json:"pool_size"`
+	DialTimeout      time.Duration `json:"dial_timeout"`
+	ReadTimeout      time.Duration `json:"read_timeout"`
+	WriteTimeout     time.Duration `json:"write_timeout"`
+	TLS              bool          `json:"tls"`
+	// TLSCAFile is a PEM bundle of the CAs trusted for the server certificate.
+	// The system roots are used when it is empty.
+	TLSCAFile string `json:"tls_ca_file"`
+	// TLSCertFile and TLSKeyFile hold the client certificate for mutual TLS.
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+	// TLSServerName overrides the name the server certificate is verified
+	// against, which defaults to the host of each dialled address.
+	TLSServerName         string `json:"tls_server_name"`
+	TLSInsecureSkipVerify bool   `json:"tls_insecure_skip_verify"`
+	// DisableClientCache turns off rueidis client-side caching, which needs a
+	// server supporting RESP3 and CLIENT TRACKING.
+	DisableClientCache bool `json:"disable_client_cache"`
+	// Compression is CompressionZstd or CompressionSnappy to compress cached
+	// values of at least CompressionThreshold bytes.
+	Compression          string `json:"compression"`
+	CompressionThreshold int    `json:"compression_threshold"`
+	// EncryptionKeys maps key IDs to base64 AES keys. When set, cached values
+	// are encrypted with the key EncryptionKeyId.
+	EncryptionKeys  map[string]string `json:"encryption_keys"`
+	EncryptionKeyId string            `json:"encryption_key_id"`
+}
+
+// Validate reports the first misconfiguration found in cfg. The constructors
+// call it, so a bad config fails at startup instead of on the first command.
+func (cfg Config) Validate() error {
+	switch cfg.Driver {
+	case "", DriverGoRedis, DriverRueidis:
+	default:
+		return errors.Wrap(ErrInvalidConfig, fmt.Sprintf("unknown driver %q", cfg.Driver))
+	}
+
+	switch cfg.Mode {
+	case "", ModeStandalone:
+		if cfg.Host == "" {
+			return errors.Wrap(ErrInvalidConfig, "host is required")
+		}
+		if cfg.Port <= 0 || cfg.Port > 65535 {
+			return errors.Wrap(ErrInvalidConfig, fmt.Sprintf("invalid port %d", cfg.Port))
+		}
+	case ModeSentinel:
+		if cfg.MasterName == "" {
+			return errors.Wrap(ErrInvalidConfig, "master_name is required in sentinel mode")
+		}
+		if len(cfg.Addrs) == 0 {
+			return errors.Wrap(ErrInvalidConfig, "addrs must list the sentinels in sentinel mode")
+		}
+	case ModeCluster:
+		if len(cfg.Addrs) == 0 {
+			return errors.Wrap(ErrInvalidConfig, "addrs must list the seed nodes in cluster mode")
+		}
+		if cfg.Db != 0 {
+			return errors.Wrap(ErrInvalidConfig, "cluster mode only supports db 0")
+		}
+	default:
+		return errors.Wrap(ErrInvalidConfig, fmt.Sprintf("unknown mode %q", cfg.Mode))
+	}
+
+	if cfg.Db < 0 {
+		return errors.Wrap(ErrInvalidConfig, fmt.Sprintf("invalid db %d", cfg.Db))
+	}
+	if cfg.PoolSize < 0 {
+		return errors.Wrap(ErrInvalidConfig, fmt.Sprintf("invalid pool_size %d", cfg.PoolSize))
+	}
+	if cfg.DialTimeout < 0 || cfg.ReadTimeout < 0 || cfg.WriteTimeout < 0 {
+		return errors.Wrap(ErrInvalidConfig, "timeouts must not be negative")
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return errors.Wrap(ErrInvalidConfig, "tls_cert_file and tls_key_file must be set together")
+	}
+	if !cfg.TLS && (cfg.TLSCAFile != "" || cfg.TLSCertFile != "" || cfg.TLSServerName != "") {
+		return errors.Wrap(ErrInvalidConfig, "tls options are set but tls is disabled")
+	}
+	if cfg.CompressionThreshold < 0 {
+		return errors.Wrap(ErrInvalidConfig, fmt.Sprintf("invalid compression_threshold %d", cfg.CompressionThreshold))
+	}
+	if _, err := cfg.codecs(); err != nil {
+		return err
+	}
+	// Load the certificates now so unreadable files are reported here.
+	_, err := cfg.tlsConfig()
+	return err
+}
+
+// codecs returns the value codecs configured by cfg, compression first.
+func (cfg Config) codecs() ([]Codec, error) {
+	var codecs []Codec
+	if cfg.Compression != "" {
+		codec, err := NewCompressionCodec(cfg.Compression, cfg.CompressionThreshold)
+		if err != nil {
+			return nil, err
+		}
+		codecs = append(codecs, codec)
+	}
+	if len(cfg.EncryptionKeys) > 0 || cfg.EncryptionKeyId != "" {
+		codec, err := NewAESGCMCodec(cfg.EncryptionKeyId, cfg.EncryptionKeys)
+		if err != nil {
+			return nil, err
+		}
+		codecs = append(codecs, codec)
+	}
+	return codecs, nil
+}
+
+// addrs returns the addresses the driver connects to first.
+func (cfg Config) addrs() []string {
+	if cfg.Mode == ModeSentinel || cfg.Mode == ModeCluster {
+		return cfg.Addrs
+	}
+	return []string{cfg.Host + ":" + strconv.Itoa(cfg.Port)}
+}
+
+func (cfg Config) tlsConfig() (*tls.Config, error) {
+	if !cfg.TLS {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.TLSServerName,
+		InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
+	}
+	if cfg.TLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to read tls_ca_file %s", cfg.TLSCAFile))
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Wrap(ErrInvalidConfig, fmt.Sprintf("no certificates found in tls_ca_file %s", cfg.TLSCAFile))
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load tls client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
 }
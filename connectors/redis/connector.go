@@ -0,0 +1,62 @@
+package redis
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/redis/rueidis"
+	"time"
+)
+
+const (
+	DriverGoRedis = "go-redis"
+	DriverRueidis = "rueidis"
+)
+
+// Connector is the command set Cache and the rate limiter are built on. Both
+// the go-redis and the rueidis driver implement it.
+type Connector interface {
+	Ping(ctx context.Context) error
+	// Get returns ErrKeyNotFound if key does not exist.
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, value string, expiration time.Duration) error
+	Del(ctx context.Context, keys ...string) error
+	// Eval runs script, preferring EVALSHA over sending the script source. A
+	// nil reply is returned as a nil value.
+	Eval(ctx context.Context, script *Script, keys []string, args []string) (any, error)
+	// Do runs the read-only command name on keys with args, sparing simple
+	// reads the cost of a script. A nil reply is returned as a nil value.
+	Do(ctx context.Context, name string, keys []string, args []string) (any, error)
+	Close() error
+}
+
+// Script is a Lua script that can be run on any Connector.
+type Script struct {
+	goRedis *redis.Script
+	rueidis *rueidis.Lua
+}
+
+func NewScript(src string) *Script {
+	return &Script{
+		goRedis: redis.NewScript(src),
+		rueidis: rueidis.NewLuaScript(src),
+	}
+}
+
+// NewConnector validates cfg and creates a Connector on the driver selected by
+// cfg.Driver.
+func NewConnector(cfg Config) (Connector, error) {
+	switch cfg.Driver {
+	case DriverRueidis:
+		client, err := NewRueidisClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return NewRueidisConnector(client), nil
+	default:
+		client, err := NewGoRedisClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return NewGoRedisConnector(client), nil
+	}
+}
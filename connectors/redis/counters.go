@@ -0,0 +1,253 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/errors"
+	"strconv"
+	"time"
+)
+
+// Counters and HyperLogLogs get their TTL when they are created, so a daily
+// counter expires a fixed time after its first increment instead of being
+// extended by every later one. ARGV[1] is always the TTL in milliseconds.
+
+var incrBy = NewScript(`
+local value = redis.call("INCRBY", KEYS[1], ARGV[2])
+if tonumber(ARGV[1]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+  redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return value
+`)
+
+var pfAdd = NewScript(`
+local changed = redis.call("PFADD", KEYS[1], unpack(ARGV, 2))
+if tonumber(ARGV[1]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+  redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return changed
+`)
+
+// Incr increments the counter key by one and returns its new value. A new
+// counter expires after ttl, zero keeps it forever.
+func (cache *Cache) Incr(ctx *context.Context, key string, ttl time.Duration) (int64, error) {
+	return cache.IncrBy(ctx, key, 1, ttl)
+}
+
+// IncrBy increments the counter key by n, see Incr.
+func (cache *Cache) IncrBy(ctx *context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	result, err := cache.conn.Eval(ctx.Context, incrBy, []string{key}, []string{
+		milliseconds(ttl),
+		strconv.FormatInt(n, 10),
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("failed to increment counter %s", key))
+	}
+	return int64Reply(result)
+}
+
+// PFAdd adds elements to the HyperLogLog key and reports whether its estimated
+// cardinality changed. A new HyperLogLog expires after ttl, zero keeps it
+// forever.
+func (cache *Cache) PFAdd(ctx *context.Context, key string, ttl time.Duration, elements ...string) (bool, error) {
+	args := append([]string{milliseconds(ttl)}, elements...)
+	result, err := cache.conn.Eval(ctx.Context, pfAdd, []string{key}, args)
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to add to hyperloglog %s", key))
+	}
+	changed, err := int64Reply(result)
+	return changed == 1, err
+}
+
+// PFCount returns the estimated number of distinct elements added to the union
+// of the HyperLogLogs keys. In cluster mode all keys must share a hash slot.
+func (cache *Cache) PFCount(ctx *context.Context, keys ...string) (int64, error) {
+	result, err := cache.conn.Do(ctx.Context, "PFCOUNT", keys, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count hyperloglog")
+	}
+	return int64Reply(result)
+}
+
+// Leaderboard is a sorted set ranking members by score, highest first.
+type Leaderboard struct {
+	cache *Cache
+	key   string
+	ttl   time.Duration
+}
+
+// LeaderboardEntry is a member with its score and its 1-based rank.
+type LeaderboardEntry struct {
+	Member string
+	Score  float64
+	Rank   int64
+}
+
+// NewLeaderboard returns the leaderboard stored at key. It expires ttl after
+// its first member was added, zero keeps it forever.
+func NewLeaderboard(cache *Cache, key string, ttl time.Duration) *Leaderboard {
+	return &Leaderboard{
+		cache: cache,
+		key:   key,
+		ttl:   ttl,
+	}
+}
+
+var leaderboardAdd = NewScript(`
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[3])
+if tonumber(ARGV[1]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+  redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+var leaderboardIncrBy = NewScript(`
+local score = redis.call("ZINCRBY", KEYS[1], ARGV[2], ARGV[3])
+if tonumber(ARGV[1]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+  redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return score
+`)
+
+var leaderboardRemove = NewScript(`
+return redis.call("ZREM", KEYS[1], unpack(ARGV))
+`)
+
+// Add sets the score of member.
+func (l *Leaderboard) Add(ctx *context.Context, member string, score float64) error {
+	_, err := l.cache.conn.Eval(ctx.Context, leaderboardAdd, []string{l.key}, []string{
+		milliseconds(l.ttl),
+		formatScore(score),
+		member,
+	})
+	return errors.Wrap(err, fmt.Sprintf("failed to add %s to leaderboard %s", member, l.key))
+}
+
+// IncrBy adds delta to the score of member and returns the new score.
+func (l *Leaderboard) IncrBy(ctx *context.Context, member string, delta float64) (float64, error) {
+	result, err := l.cache.conn.Eval(ctx.Context, leaderboardIncrBy, []string{l.key}, []string{
+		milliseconds(l.ttl),
+		formatScore(delta),
+		member,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("failed to increment %s in leaderboard %s", member, l.key))
+	}
+	return parseScore(result)
+}
+
+// Rank returns the entry of member, or ErrKeyNotFound if it is not ranked.
+func (l *Leaderboard) Rank(ctx *context.Context, member string) (*LeaderboardEntry, error) {
+	rank, err := l.rank(ctx, member)
+	if err != nil {
+		return nil, err
+	}
+	result, err := l.cache.conn.Do(ctx.Context, "ZSCORE", []string{l.key}, []string{member})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to rank %s in leaderboard %s", member, l.key))
+	}
+	if result == nil {
+		// Removed since it was ranked.
+		return nil, ErrKeyNotFound
+	}
+	score, err := parseScore(result)
+	if err != nil {
+		return nil, err
+	}
+	return &LeaderboardEntry{Member: member, Score: score, Rank: rank + 1}, nil
+}
+
+// Top returns the n best ranked entries.
+func (l *Leaderboard) Top(ctx *context.Context, n int64) ([]LeaderboardEntry, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	return l.revRange(ctx, 0, n-1)
+}
+
+// Around returns member with the n entries ranked above and below it, or
+// ErrKeyNotFound if member is not ranked.
+func (l *Leaderboard) Around(ctx *context.Context, member string, n int64) ([]LeaderboardEntry, error) {
+	rank, err := l.rank(ctx, member)
+	if err != nil {
+		return nil, err
+	}
+	n = max(n, 0)
+	return l.revRange(ctx, max(rank-n, 0), rank+n)
+}
+
+// rank returns the 0-based rank of member, or ErrKeyNotFound.
+func (l *Leaderboard) rank(ctx *context.Context, member string) (int64, error) {
+	result, err := l.cache.conn.Do(ctx.Context, "ZREVRANK", []string{l.key}, []string{member})
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("failed to rank %s in leaderboard %s", member, l.key))
+	}
+	if result == nil {
+		return 0, ErrKeyNotFound
+	}
+	return int64Reply(result)
+}
+
+// revRange returns the entries ranked from the 0-based ranks first to last.
+func (l *Leaderboard) revRange(ctx *context.Context, first int64, last int64) ([]LeaderboardEntry, error) {
+	result, err := l.cache.conn.Do(ctx.Context, "ZREVRANGE", []string{l.key}, []string{
+		strconv.FormatInt(first, 10),
+		strconv.FormatInt(last, 10),
+		"WITHSCORES",
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to read leaderboard %s", l.key))
+	}
+	return parseEntries(result, first)
+}
+
+// Remove removes members from the leaderboard.
+func (l *Leaderboard) Remove(ctx *context.Context, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	_, err := l.cache.conn.Eval(ctx.Context, leaderboardRemove, []string{l.key}, members)
+	return errors.Wrap(err, fmt.Sprintf("failed to remove from leaderboard %s", l.key))
+}
+
+// parseEntries parses a WITHSCORES reply starting at the 0-based rank first.
+// RESP2 replies alternate members and scores, RESP3 replies hold a pair per
+// entry.
+func parseEntries(result any, first int64) ([]LeaderboardEntry, error) {
+	reply, _ := result.([]any)
+	var pairs [][2]any
+	for i := 0; i < len(reply); i++ {
+		if pair, ok := reply[i].([]any); ok && len(pair) == 2 {
+			pairs = append(pairs, [2]any{pair[0], pair[1]})
+		} else if i+1 < len(reply) {
+			pairs = append(pairs, [2]any{reply[i], reply[i+1]})
+			i++
+		}
+	}
+	entries := make([]LeaderboardEntry, 0, len(pairs))
+	for i, pair := range pairs {
+		member, ok := pair[0].(string)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("unexpected leaderboard member %v (%T)", pair[0], pair[0]))
+		}
+		score, err := parseScore(pair[1])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, LeaderboardEntry{
+			Member: member,
+			Score:  score,
+			Rank:   first + int64(i) + 1,
+		})
+	}
+	return entries, nil
+}
+
+func parseScore(value any) (float64, error) {
+	score, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+	return score, errors.Wrap(err, fmt.Sprintf("invalid leaderboard score %v", value))
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
@@ -0,0 +1,89 @@
+package redis_test
+
+import (
+	"github.com/NitinD97/common-utils/connectors/redis"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/errors"
+	"testing"
+	"time"
+)
+
+func TestCounterSubMillisecondTTL(t *testing.T) {
+	for driver, newCache := range drivers() {
+		t.Run(driver, func(t *testing.T) {
+			cache, server := newCache(t)
+			ctx := context.NewContext()
+
+			if _, err := cache.Incr(ctx, "hits", 500*time.Microsecond); err != nil {
+				t.Fatal(err)
+			}
+			if ttl := server.TTL("hits"); ttl != time.Millisecond {
+				t.Fatalf("expected the ttl to round up to 1ms, got %v", ttl)
+			}
+		})
+	}
+}
+
+func TestHyperLogLog(t *testing.T) {
+	for driver, newCache := range drivers() {
+		t.Run(driver, func(t *testing.T) {
+			cache, _ := newCache(t)
+			ctx := context.NewContext()
+
+			changed, err := cache.PFAdd(ctx, "visitors", time.Hour, "a", "b", "c")
+			if err != nil || !changed {
+				t.Fatalf("got %v, %v", changed, err)
+			}
+			if changed, err = cache.PFAdd(ctx, "visitors", time.Hour, "a"); err != nil || changed {
+				t.Fatalf("got %v, %v", changed, err)
+			}
+			if count, err := cache.PFCount(ctx, "visitors"); err != nil || count != 3 {
+				t.Fatalf("got %d, %v", count, err)
+			}
+			if count, err := cache.PFCount(ctx, "nobody"); err != nil || count != 0 {
+				t.Fatalf("got %d, %v", count, err)
+			}
+		})
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	for driver, newCache := range drivers() {
+		t.Run(driver, func(t *testing.T) {
+			cache, _ := newCache(t)
+			ctx := context.NewContext()
+			board := redis.NewLeaderboard(cache, "board", time.Hour)
+
+			for i, member := range []string{"a", "b", "c", "d", "e"} {
+				if err := board.Add(ctx, member, float64(10*(i+1))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if score, err := board.IncrBy(ctx, "a", 45.5); err != nil || score != 55.5 {
+				t.Fatalf("got %v, %v", score, err)
+			}
+
+			entry, err := board.Rank(ctx, "a")
+			if err != nil || *entry != (redis.LeaderboardEntry{Member: "a", Score: 55.5, Rank: 1}) {
+				t.Fatalf("got %+v, %v", entry, err)
+			}
+			if _, err := board.Rank(ctx, "z"); !errors.Is(err, redis.ErrKeyNotFound) {
+				t.Fatalf("expected ErrKeyNotFound, got %v", err)
+			}
+
+			top, err := board.Top(ctx, 2)
+			want := []redis.LeaderboardEntry{{Member: "a", Score: 55.5, Rank: 1}, {Member: "e", Score: 50, Rank: 2}}
+			if err != nil || len(top) != 2 || top[0] != want[0] || top[1] != want[1] {
+				t.Fatalf("got %+v, %v", top, err)
+			}
+
+			around, err := board.Around(ctx, "c", 1)
+			if err != nil || len(around) != 3 || around[0].Member != "d" || around[1].Rank != 4 || around[2].Member != "b" {
+				t.Fatalf("got %+v, %v", around, err)
+			}
+			if _, err := board.Around(ctx, "z", 1); !errors.Is(err, redis.ErrKeyNotFound) {
+				t.Fatalf("expected ErrKeyNotFound, got %v", err)
+			}
+		})
+	}
+}
@@ -3,3 +3,17 @@ package redis
 import "errors"
 
 var ErrKeyNotFound = errors.New("key not found")
+
+var ErrInvalidConfig = errors.New("invalid redis config")
+
+// ErrUnsupportedDriver is returned by features that need the go-redis driver,
+// such as streams, pub/sub and the job queue, when the Cache runs on rueidis.
+var ErrUnsupportedDriver = errors.New("operation not supported by the redis driver")
+
+// ErrUnknownCodec is returned when reading a value encoded with a codec the
+// Cache was not configured with.
+var ErrUnknownCodec = errors.New("value was encoded with an unknown codec")
+
+// ErrNoSession is returned by SessionStore methods called outside of a request
+// handled by its Middleware.
+var ErrNoSession = errors.New("no session in context")
@@ -0,0 +1,106 @@
+package redis
+
+import (
+	"context"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+type goRedisConnector struct {
+	client redis.UniversalClient
+}
+
+// NewGoRedisConnector wraps an existing go-redis client.
+func NewGoRedisConnector(client redis.UniversalClient) Connector {
+	return &goRedisConnector{client: client}
+}
+
+// NewGoRedisClient validates cfg and creates a go-redis client for its mode: a
+// plain client, a sentinel-backed failover client or a cluster client.
+func NewGoRedisClient(cfg Config) (redis.UniversalClient, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	opts := &redis.UniversalOptions{
+		Addrs:            cfg.addrs(),
+		MasterName:       cfg.MasterName,
+		SentinelUsername: cfg.SentinelUsername,
+		SentinelPassword: cfg.SentinelPassword,
+		Username:         cfg.Username,
+		Password:         cfg.Password,
+		DB:               cfg.Db,
+		PoolSize:         cfg.PoolSize,
+		DialTimeout:      cfg.DialTimeout,
+		ReadTimeout:      cfg.ReadTimeout,
+		WriteTimeout:     cfg.WriteTimeout,
+		TLSConfig:        tlsConfig,
+	}
+	switch cfg.Mode {
+	case ModeSentinel:
+		return redis.NewFailoverClient(opts.Failover()), nil
+	case ModeCluster:
+		return redis.NewClusterClient(opts.Cluster()), nil
+	default:
+		return redis.NewClient(opts.Simple()), nil
+	}
+}
+
+func (c *goRedisConnector) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+func (c *goRedisConnector) Get(ctx context.Context, key string) (string, error) {
+	result, err := c.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrKeyNotFound
+	}
+	return result, err
+}
+
+func (c *goRedisConnector) Set(ctx context.Context, key string, value string, expiration time.Duration) error {
+	return c.client.Set(ctx, key, value, expiration).Err()
+}
+
+func (c *goRedisConnector) Del(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.client.Del(ctx, keys...).Err()
+}
+
+func (c *goRedisConnector) Eval(ctx context.Context, script *Script, keys []string, args []string) (any, error) {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg
+	}
+	result, err := script.goRedis.Run(ctx, c.client, keys, values...).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return result, err
+}
+
+func (c *goRedisConnector) Do(ctx context.Context, name string, keys []string, args []string) (any, error) {
+	values := make([]interface{}, 0, 1+len(keys)+len(args))
+	values = append(values, name)
+	for _, key := range keys {
+		values = append(values, key)
+	}
+	for _, arg := range args {
+		values = append(values, arg)
+	}
+	result, err := c.client.Do(ctx, values...).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return result, err
+}
+
+func (c *goRedisConnector) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,12 @@
+package redis
+
+import (
+	"github.com/NitinD97/common-utils/health"
+	"go.uber.org/zap"
+)
+
+// NewHealthMonitor returns a health.Monitor pinging the server behind cache on
+// either driver, to be registered with a health.Registry.
+func NewHealthMonitor(name string, cache *Cache, cfg health.MonitorConfig, logger *zap.Logger) *health.Monitor {
+	return health.NewMonitor(name, health.CheckerFunc(cache.conn.Ping), cfg, logger)
+}
@@ -0,0 +1,293 @@
+package redis
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IdempotentReplayedHeader is set on responses replayed from the store.
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// pendingPrefix marks a key whose first request is still in flight. Stored
+// responses are JSON or codec envelopes and never start with it.
+const pendingPrefix = "pending:"
+
+// defaultReplayHeaders describe the stored body. Headers such as Set-Cookie or
+// the request ID belong to the first request and are not replayed.
+var defaultReplayHeaders = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Language",
+	"Content-Type",
+	"ETag",
+	"Last-Modified",
+	"Location",
+}
+
+type IdempotencyConfig struct {
+	// Header carrying the key, "Idempotency-Key" by default. Requests without
+	// it are passed through.
+	Header string `json:"header"`
+	// TTL of stored responses, 24h by default.
+	TTL time.Duration `json:"ttl"`
+	// LockTimeout bounds how long a key stays locked by a request that never
+	// finishes, 1m by default.
+	LockTimeout time.Duration `json:"lock_timeout"`
+	// KeyPrefix of the idempotency keys in Redis, "idempotency" by default.
+	KeyPrefix string `json:"key_prefix"`
+	// Scope returns the owner of the key, e.g. the authenticated user, so
+	// clients cannot replay each other's responses.
+	Scope func(ginCtx *gin.Context) string `json:"-"`
+	// ReplayHeaders are the response headers stored and replayed to retries,
+	// Content-Type, Location and the other headers describing the body by
+	// default.
+	ReplayHeaders []string `json:"replay_headers"`
+}
+
+func (cfg IdempotencyConfig) withDefaults() IdempotencyConfig {
+	if cfg.Header == "" {
+		cfg.Header = "Idempotency-Key"
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	if cfg.LockTimeout <= 0 {
+		cfg.LockTimeout = time.Minute
+	}
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = "idempotency"
+	}
+	if len(cfg.ReplayHeaders) == 0 {
+		cfg.ReplayHeaders = defaultReplayHeaders
+	}
+	return cfg
+}
+
+// idempotentResponse is the stored response of a request.
+type idempotentResponse struct {
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// acquireIdempotencyKey locks KEYS[1] with the pending marker ARGV[1] unless
+// it is set, in which case the current value is returned.
+var acquireIdempotencyKey = NewScript(`
+local current = redis.call("GET", KEYS[1])
+if current then
+  return current
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return false
+`)
+
+// completeIdempotencyKey replaces the pending marker ARGV[1] of KEYS[1] with
+// the response ARGV[2], or deletes the key if ARGV[2] is empty. A lock that
+// expired and was taken over is left alone.
+var completeIdempotencyKey = NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+  return 0
+end
+if ARGV[2] == "" then
+  redis.call("DEL", KEYS[1])
+else
+  redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+end
+return 1
+`)
+
+// NewIdempotencyMiddleware returns a gin middleware running a request once per
+// idempotency key. While the first request is in flight, retries get 409. Once
+// it finished, retries get its stored response, unless their method, path or
+// body differ, which is answered with 422. Responses with a 5xx status are not
+// stored, so the request can be retried.
+func NewIdempotencyMiddleware(cache *Cache, cfg IdempotencyConfig, logger *zap.Logger) gin.HandlerFunc {
+	cfg = cfg.withDefaults()
+	if logger == nil {
+		logger = cache.logger
+	}
+	return func(ginCtx *gin.Context) {
+		idempotencyKey := ginCtx.GetHeader(cfg.Header)
+		if idempotencyKey == "" {
+			ginCtx.Next()
+			return
+		}
+		// Not cancelled with the request, so the response is stored even if
+		// the client went away.
+		ctx := context.NewContextFromGinContext(ginCtx)
+
+		body, err := io.ReadAll(ginCtx.Request.Body)
+		if err != nil {
+			ginCtx.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		ginCtx.Request.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(ginCtx.Request, body)
+
+		key := cfg.KeyPrefix + keySeparator
+		if cfg.Scope != nil {
+			key += partEscaper.Replace(cfg.Scope(ginCtx)) + keySeparator
+		}
+		key += partEscaper.Replace(idempotencyKey)
+
+		pending := pendingPrefix + newIdempotencyToken() + ":" + fingerprint
+		current, err := cache.conn.Eval(ctx.Context, acquireIdempotencyKey, []string{key}, []string{
+			pending,
+			strconv.FormatInt(cfg.LockTimeout.Milliseconds(), 10),
+		})
+		if err != nil {
+			logger.Error("Failed to lock idempotency key",
+				zap.String("key", idempotencyKey),
+				zap.Any(enums.RequestId, requestId(ctx)),
+				zap.Error(err),
+			)
+			ginCtx.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		if current != nil {
+			replayIdempotentResponse(ginCtx, cache, key, current.(string), fingerprint)
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: ginCtx.Writer}
+		ginCtx.Writer = writer
+		completed := false
+		defer func() {
+			// Release the key if a handler panicked.
+			if !completed {
+				finishIdempotencyKey(ctx, cache, key, pending, "", 0, logger)
+			}
+		}()
+
+		ginCtx.Next()
+
+		completed = true
+		if writer.Status() >= http.StatusInternalServerError {
+			finishIdempotencyKey(ctx, cache, key, pending, "", 0, logger)
+			return
+		}
+		response, err := json.Marshal(idempotentResponse{
+			Fingerprint: fingerprint,
+			Status:      writer.Status(),
+			Header:      replayHeaders(writer.Header(), cfg.ReplayHeaders),
+			Body:        writer.body.Bytes(),
+		})
+		if err == nil {
+			response, err = cache.encode(key, response)
+		}
+		if err != nil {
+			logger.Error("Failed to encode idempotent response",
+				zap.String("key", idempotencyKey),
+				zap.Any(enums.RequestId, requestId(ctx)),
+				zap.Error(err),
+			)
+			finishIdempotencyKey(ctx, cache, key, pending, "", 0, logger)
+			return
+		}
+		finishIdempotencyKey(ctx, cache, key, pending, string(response), cfg.TTL, logger)
+	}
+}
+
+func replayIdempotentResponse(ginCtx *gin.Context, cache *Cache, key string, current string, fingerprint string) {
+	if strings.HasPrefix(current, pendingPrefix) {
+		if !strings.HasSuffix(current, ":"+fingerprint) {
+			ginCtx.AbortWithStatus(http.StatusUnprocessableEntity)
+			return
+		}
+		ginCtx.AbortWithStatus(http.StatusConflict)
+		return
+	}
+	var response idempotentResponse
+	decoded, err := cache.decode(key, []byte(current))
+	if err == nil {
+		err = json.Unmarshal(decoded, &response)
+	}
+	if err != nil {
+		ginCtx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if response.Fingerprint != fingerprint {
+		ginCtx.AbortWithStatus(http.StatusUnprocessableEntity)
+		return
+	}
+	for name, values := range response.Header {
+		ginCtx.Writer.Header()[name] = values
+	}
+	ginCtx.Header(IdempotentReplayedHeader, "true")
+	ginCtx.Status(response.Status)
+	_, _ = ginCtx.Writer.Write(response.Body)
+	ginCtx.Abort()
+}
+
+// replayHeaders returns the headers named by names.
+func replayHeaders(header http.Header, names []string) http.Header {
+	stored := http.Header{}
+	for _, name := range names {
+		if values := header.Values(name); len(values) > 0 {
+			stored[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+		}
+	}
+	return stored
+}
+
+// finishIdempotencyKey stores response under key, or releases the key if
+// response is empty.
+func finishIdempotencyKey(ctx *context.Context, cache *Cache, key string, pending string, response string, ttl time.Duration, logger *zap.Logger) {
+	// Store the response even if the client went away.
+	_, err := cache.conn.Eval(ctx.WithoutCancel().Context, completeIdempotencyKey, []string{key}, []string{
+		pending,
+		response,
+		strconv.FormatInt(ttl.Milliseconds(), 10),
+	})
+	if err != nil {
+		logger.Error("Failed to complete idempotency key",
+			zap.String("key", key),
+			zap.Any(enums.RequestId, requestId(ctx)),
+			zap.Error(err),
+		)
+	}
+}
+
+// requestFingerprint identifies the method, path and body of a request.
+func requestFingerprint(request *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(request.Method + " " + request.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func newIdempotencyToken() string {
+	token := make([]byte, 16)
+	_, _ = rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// recordingWriter keeps a copy of the response body.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
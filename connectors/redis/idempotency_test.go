@@ -0,0 +1,58 @@
+package redis_test
+
+import (
+	"github.com/NitinD97/common-utils/connectors/redis"
+	"github.com/NitinD97/common-utils/connectors/redis/redistest"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestIdempotencyReplaysBodyHeadersOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cache, _ := redistest.NewCache(t)
+	calls := 0
+	router := gin.New()
+	router.Use(func(ginCtx *gin.Context) {
+		ginCtx.Header("X-Request-Id", "request-"+strconv.Itoa(calls+1))
+	})
+	router.Use(redis.NewIdempotencyMiddleware(cache, redis.IdempotencyConfig{}, nil))
+	router.POST("/payments", func(ginCtx *gin.Context) {
+		calls++
+		ginCtx.Header("Location", "/payments/1")
+		ginCtx.SetCookie("session", "secret", 3600, "/", "", true, true)
+		ginCtx.JSON(http.StatusCreated, gin.H{"id": 1})
+	})
+
+	post := func() *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/payments", nil)
+		request.Header.Set("Idempotency-Key", "key-1")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	first := post()
+	retry := post()
+
+	if calls != 1 {
+		t.Fatalf("handler ran %d times", calls)
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Fatalf("replayed %d %q, want %d %q", retry.Code, retry.Body, first.Code, first.Body)
+	}
+	if retry.Header().Get(redis.IdempotentReplayedHeader) != "true" {
+		t.Error("replayed response is not marked")
+	}
+	for name, want := range map[string]string{
+		"Location":     "/payments/1",
+		"Content-Type": first.Header().Get("Content-Type"),
+		"Set-Cookie":   "",
+		"X-Request-Id": "request-2",
+	} {
+		if got := retry.Header().Get(name); got != want {
+			t.Errorf("replayed %s %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CommandHook is a go-redis hook logging every command with its latency, key
+// and the request ID of its context. Values are never logged.
+type CommandHook struct {
+	logger *zap.Logger
+	level  zapcore.Level
+	redact func(key string) string
+}
+
+type CommandHookOption func(*CommandHook)
+
+// WithCommandLogLevel sets the level successful commands are logged at,
+// Debug by default. Failed commands are always logged at Error.
+func WithCommandLogLevel(level zapcore.Level) CommandHookOption {
+	return func(h *CommandHook) {
+		h.level = level
+	}
+}
+
+// WithKeyRedaction rewrites keys before they are logged, e.g. with RedactKey.
+func WithKeyRedaction(redact func(key string) string) CommandHookOption {
+	return func(h *CommandHook) {
+		h.redact = redact
+	}
+}
+
+// RedactKey keeps the first segment of a colon separated key and masks the
+// rest, so "session:3f2a..." is logged as "session:***".
+func RedactKey(key string) string {
+	if i := strings.IndexByte(key, ':'); i >= 0 {
+		return key[:i+1] + "***"
+	}
+	return "***"
+}
+
+func NewCommandHook(logger *zap.Logger, opts ...CommandHookOption) *CommandHook {
+	hook := &CommandHook{
+		logger: logger,
+		level:  zapcore.DebugLevel,
+	}
+	for _, opt := range opts {
+		opt(hook)
+	}
+	return hook
+}
+
+// WithInstrumentation logs the commands of the Cache through a CommandHook.
+// It only has an effect on the go-redis driver.
+func WithInstrumentation(logger *zap.Logger, opts ...CommandHookOption) CacheOption {
+	return func(c *Cache) {
+		if c.rDB != nil {
+			c.rDB.AddHook(NewCommandHook(logger, opts...))
+		}
+	}
+}
+
+func (h *CommandHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			h.logger.Error("Redis dial failed",
+				zap.String("addr", addr),
+				zap.Any(enums.RequestId, ctx.Value(enums.RequestId)),
+				zap.Error(err),
+			)
+		}
+		return conn, err
+	}
+}
+
+func (h *CommandHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.log(ctx, "Redis command", []redis.Cmder{cmd}, time.Since(start), err)
+		return err
+	}
+}
+
+func (h *CommandHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.log(ctx, "Redis pipeline", cmds, time.Since(start), err)
+		return err
+	}
+}
+
+func (h *CommandHook) log(ctx context.Context, msg string, cmds []redis.Cmder, latency time.Duration, err error) {
+	// A missing key is a regular outcome, not a failure, and so is NOSCRIPT,
+	// which scripts recover from by falling back to EVAL.
+	if err != nil && !errors.Is(err, redis.Nil) && !strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		h.logger.Error(msg+" failed",
+			zap.Strings("commands", h.describe(cmds)),
+			zap.Duration("latency", latency),
+			zap.Any(enums.RequestId, ctx.Value(enums.RequestId)),
+			zap.Error(err),
+		)
+		return
+	}
+	if ce := h.logger.Check(h.level, msg); ce != nil {
+		ce.Write(
+			zap.Strings("commands", h.describe(cmds)),
+			zap.Duration("latency", latency),
+			zap.Any(enums.RequestId, ctx.Value(enums.RequestId)),
+		)
+	}
+}
+
+// describe renders each command as its name and key.
+func (h *CommandHook) describe(cmds []redis.Cmder) []string {
+	described := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		key := commandKey(cmd)
+		if key != "" && h.redact != nil {
+			key = h.redact(key)
+		}
+		described[i] = strings.TrimSpace(cmd.Name() + " " + key)
+	}
+	return described
+}
+
+// commandKey returns the first key of cmd, or "" for commands without keys.
+func commandKey(cmd redis.Cmder) string {
+	args := cmd.Args()
+	pos := 1
+	switch cmd.Name() {
+	case "ping", "auth", "hello", "select", "client", "info", "time", "script", "cluster", "multi", "exec", "discard", "quit":
+		return ""
+	case "eval", "evalsha", "eval_ro", "evalsha_ro":
+		if len(args) < 4 || fmt.Sprint(args[2]) == "0" {
+			return ""
+		}
+		pos = 3
+	case "xreadgroup":
+		for i, arg := range args {
+			if s, ok := arg.(string); ok && strings.EqualFold(s, "streams") {
+				pos = i + 1
+				break
+			}
+		}
+	}
+	if len(args) <= pos {
+		return ""
+	}
+	return fmt.Sprint(args[pos])
+}
+
+// CacheStats counts the outcome of Get and GetJSON calls on a Cache.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// HitRatio returns the share of lookups that found a value.
+func (s CacheStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type cacheCounters struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func (c *cacheCounters) record(err error) {
+	switch {
+	case err == nil:
+		c.hits.Add(1)
+	case errors.Is(err, ErrKeyNotFound):
+		c.misses.Add(1)
+	}
+}
+
+// Stats returns the hit and miss counts of the Cache since it was created.
+func (cache *Cache) Stats() CacheStats {
+	return CacheStats{
+		Hits:   cache.counters.hits.Load(),
+		Misses: cache.counters.misses.Load(),
+	}
+}
@@ -0,0 +1,36 @@
+package redis_test
+
+import (
+	"github.com/NitinD97/common-utils/connectors/redis"
+	"github.com/NitinD97/common-utils/connectors/redis/redistest"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCommandHookLogsRequestIdOfGinRequests(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	cache, _ := redistest.NewCache(t, redis.WithInstrumentation(zap.New(core)))
+
+	ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginCtx.Request = httptest.NewRequest("GET", "/", nil)
+	ginCtx.Set(enums.RequestId, "request-1")
+	ctx := context.NewContextFromGinContext(ginCtx)
+
+	if err := cache.Set(ctx, "greeting", "hello", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	entries := logs.FilterMessage("Redis command").All()
+	if len(entries) == 0 {
+		t.Fatal("expected the command to be logged")
+	}
+	if id := entries[len(entries)-1].ContextMap()[enums.RequestId]; id != "request-1" {
+		t.Fatalf("expected the request ID of the gin context, got %v", id)
+	}
+}
@@ -0,0 +1,471 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const jobsPrefix = "jobs:"
+
+// reserveJob promotes due delayed jobs and jobs whose visibility timeout has
+// expired to the ready list, then moves the next ready job to the active set
+// and returns its ID.
+var reserveJob = redis.NewScript(`
+local ready = KEYS[1]
+local delayed = KEYS[2]
+local active = KEYS[3]
+local now = tonumber(ARGV[1])
+local visibility = tonumber(ARGV[2])
+local due = redis.call("ZRANGEBYSCORE", delayed, "-inf", now, "LIMIT", 0, 100)
+for _, id in ipairs(due) do
+  redis.call("ZREM", delayed, id)
+  redis.call("LPUSH", ready, id)
+end
+-- jobs whose worker died without acking are retried first
+local expired = redis.call("ZRANGEBYSCORE", active, "-inf", now, "LIMIT", 0, 100)
+for _, id in ipairs(expired) do
+  redis.call("ZREM", active, id)
+  redis.call("RPUSH", ready, id)
+end
+local id = redis.call("RPOP", ready)
+if id then
+  redis.call("ZADD", active, now + visibility, id)
+end
+return id
+`)
+
+// startJob counts a run of the job KEYS[1] reserved by reserveJob and returns
+// its payload, attempts and request ID. A job whose hash is gone is removed
+// from the active set KEYS[2] and false is returned.
+var startJob = redis.NewScript(`
+local fields = redis.call("HMGET", KEYS[1], "payload", "requestId")
+if not fields[1] then
+  redis.call("ZREM", KEYS[2], ARGV[1])
+  return false
+end
+local attempts = redis.call("HINCRBY", KEYS[1], "attempts", 1)
+return {fields[1], attempts, fields[2] or ""}
+`)
+
+// Job is a reserved job handed to a JobHandler.
+type Job struct {
+	ID        string
+	Queue     string
+	RequestId string
+	// Attempts counts the runs of the job including the current one.
+	Attempts int
+	Payload  json.RawMessage
+	// Error is the error of the last failed run, set for dead jobs.
+	Error string
+}
+
+// Decode unmarshals the JSON payload of the job into value.
+func (j *Job) Decode(value interface{}) error {
+	return json.Unmarshal(j.Payload, value)
+}
+
+// JobHandler runs a job. Returning an error schedules a retry with exponential
+// backoff until MaxAttempts is reached, after which the job is moved to the
+// dead queue.
+type JobHandler func(ctx *context.Context, job *Job) error
+
+// jobKeys names the keys of a queue. The queue name is a hash tag so all keys
+// of a queue live in the same cluster slot.
+type jobKeys struct {
+	ready   string
+	delayed string
+	active  string
+	dead    string
+	job     string
+}
+
+func newJobKeys(queue string) jobKeys {
+	base := jobsPrefix + "{" + queue + "}:"
+	return jobKeys{
+		ready:   base + "ready",
+		delayed: base + "delayed",
+		active:  base + "active",
+		dead:    base + "dead",
+		job:     base + "job:",
+	}
+}
+
+// JobQueue enqueues jobs and manages dead jobs.
+type JobQueue struct {
+	cache *Cache
+}
+
+func NewJobQueue(cache *Cache) *JobQueue {
+	return &JobQueue{cache: cache}
+}
+
+// Enqueue adds a job to queue that becomes runnable at runAt, or immediately if
+// runAt is zero or in the past. It returns the job ID.
+func (q *JobQueue) Enqueue(ctx *context.Context, queue string, payload interface{}, runAt time.Time) (string, error) {
+	if err := q.cache.requireGoRedis(); err != nil {
+		return "", err
+	}
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode job payload")
+	}
+	id := uuid.NewString()
+	keys := newJobKeys(queue)
+
+	_, err = q.cache.rDB.TxPipelined(ctx.Context, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx.Context, keys.job+id,
+			"payload", bytes,
+			"requestId", requestId(ctx),
+			"attempts", 0,
+		)
+		if runAt.After(time.Now()) {
+			pipe.ZAdd(ctx.Context, keys.delayed, redis.Z{Score: float64(runAt.UnixMilli()), Member: id})
+		} else {
+			pipe.LPush(ctx.Context, keys.ready, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("failed to enqueue job on queue %s", queue))
+	}
+	return id, nil
+}
+
+// DeadJobs returns up to limit jobs of queue that exhausted their attempts,
+// most recent first.
+func (q *JobQueue) DeadJobs(ctx *context.Context, queue string, limit int64) ([]*Job, error) {
+	if err := q.cache.requireGoRedis(); err != nil {
+		return nil, err
+	}
+	keys := newJobKeys(queue)
+	ids, err := q.cache.rDB.LRange(ctx.Context, keys.dead, 0, limit-1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to list dead jobs of queue %s", queue))
+	}
+	jobs := make([]*Job, 0, len(ids))
+	for _, id := range ids {
+		fields, err := q.cache.rDB.HGetAll(ctx.Context, keys.job+id).Result()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to load dead job %s", id))
+		}
+		attempts, _ := strconv.Atoi(fields["attempts"])
+		jobs = append(jobs, &Job{
+			ID:        id,
+			Queue:     queue,
+			RequestId: fields["requestId"],
+			Attempts:  attempts,
+			Payload:   json.RawMessage(fields["payload"]),
+			Error:     fields["error"],
+		})
+	}
+	return jobs, nil
+}
+
+// RetryDeadJob moves a dead job back to the ready list with its attempts reset.
+func (q *JobQueue) RetryDeadJob(ctx *context.Context, queue string, id string) error {
+	if err := q.cache.requireGoRedis(); err != nil {
+		return err
+	}
+	keys := newJobKeys(queue)
+	removed, err := q.cache.rDB.LRem(ctx.Context, keys.dead, 1, id).Result()
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to retry dead job %s", id))
+	}
+	if removed == 0 {
+		return ErrKeyNotFound
+	}
+	_, err = q.cache.rDB.TxPipelined(ctx.Context, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx.Context, keys.job+id, "attempts", 0)
+		pipe.HDel(ctx.Context, keys.job+id, "error")
+		pipe.LPush(ctx.Context, keys.ready, id)
+		return nil
+	})
+	return errors.Wrap(err, fmt.Sprintf("failed to retry dead job %s", id))
+}
+
+type JobWorkerConfig struct {
+	Queue string `json:"queue"`
+	// Concurrency is the number of jobs run in parallel.
+	Concurrency int `json:"concurrency"`
+	// MaxAttempts is how many times a job runs before it is moved to the dead
+	// queue.
+	MaxAttempts int `json:"max_attempts"`
+	// VisibilityTimeout is how long a reserved job stays invisible to other
+	// workers. It is extended while the handler runs, so it only bounds how
+	// long a job of a crashed worker waits before it is retried.
+	VisibilityTimeout time.Duration `json:"visibility_timeout"`
+	// PollInterval is how long a worker waits when the queue is empty.
+	PollInterval time.Duration `json:"poll_interval"`
+	// BackoffMin and BackoffMax bound the exponential delay between retries.
+	BackoffMin time.Duration `json:"backoff_min"`
+	BackoffMax time.Duration `json:"backoff_max"`
+}
+
+func (cfg JobWorkerConfig) withDefaults() JobWorkerConfig {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.VisibilityTimeout <= 0 {
+		cfg.VisibilityTimeout = 5 * time.Minute
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.BackoffMin <= 0 {
+		cfg.BackoffMin = time.Second
+	}
+	if cfg.BackoffMax <= 0 {
+		cfg.BackoffMax = 10 * time.Minute
+	}
+	return cfg
+}
+
+// JobWorker runs the jobs of one queue.
+type JobWorker struct {
+	cache   *Cache
+	cfg     JobWorkerConfig
+	keys    jobKeys
+	handler JobHandler
+	logger  *zap.Logger
+}
+
+func NewJobWorker(cache *Cache, cfg JobWorkerConfig, handler JobHandler, logger *zap.Logger) *JobWorker {
+	if logger == nil {
+		logger = cache.logger
+	}
+	return &JobWorker{
+		cache:   cache,
+		cfg:     cfg.withDefaults(),
+		keys:    newJobKeys(cfg.Queue),
+		handler: handler,
+		logger:  logger,
+	}
+}
+
+// Run processes jobs until ctx is cancelled. On cancellation it stops
+// reserving jobs, waits for running jobs to finish and returns nil.
+func (w *JobWorker) Run(ctx *context.Context) error {
+	if err := w.cache.requireGoRedis(); err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < w.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (w *JobWorker) loop(ctx *context.Context) {
+	for ctx.Context.Err() == nil {
+		job, err := w.reserve(ctx)
+		if err != nil {
+			if ctx.Context.Err() == nil {
+				w.logger.Error("Failed to reserve job",
+					zap.String("queue", w.cfg.Queue),
+					zap.Error(err),
+				)
+			}
+			sleepContext(ctx, w.cfg.PollInterval)
+			continue
+		}
+		if job == nil {
+			sleepContext(ctx, w.cfg.PollInterval)
+			continue
+		}
+		w.process(ctx, job)
+	}
+}
+
+// reserve returns the next ready job, or nil if there is none. The job is
+// reserved and started by two scripts, since the key of its hash is only known
+// once it is reserved; a worker dying in between leaves it in the active set,
+// so it is retried after the visibility timeout.
+func (w *JobWorker) reserve(ctx *context.Context) (*Job, error) {
+	for {
+		id, err := reserveJob.Run(ctx.Context, w.cache.rDB,
+			[]string{w.keys.ready, w.keys.delayed, w.keys.active},
+			time.Now().UnixMilli(),
+			w.cfg.VisibilityTimeout.Milliseconds(),
+		).Text()
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		job, err := w.start(ctx, id)
+		if job != nil || err != nil {
+			return job, err
+		}
+	}
+}
+
+// start counts a run of the reserved job id, returning nil if the job no
+// longer exists.
+func (w *JobWorker) start(ctx *context.Context, id string) (*Job, error) {
+	result, err := startJob.Run(ctx.Context, w.cache.rDB,
+		[]string{w.keys.job + id, w.keys.active},
+		id,
+	).Slice()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	payload, _ := result[0].(string)
+	attempts, _ := result[1].(int64)
+	reqId, _ := result[2].(string)
+	return &Job{
+		ID:        id,
+		Queue:     w.cfg.Queue,
+		RequestId: reqId,
+		Attempts:  int(attempts),
+		Payload:   json.RawMessage(payload),
+	}, nil
+}
+
+func (w *JobWorker) process(ctx *context.Context, job *Job) {
+	// Let the job and its bookkeeping finish even if ctx is cancelled meanwhile.
+	jobCtx := ctx.WithoutCancel()
+	if job.RequestId != "" {
+		jobCtx.Set(enums.RequestId, job.RequestId)
+	}
+
+	// A job that keeps crashing its worker is never failed explicitly.
+	if job.Attempts > w.cfg.MaxAttempts {
+		w.bury(jobCtx, job, "visibility timeout exceeded")
+		return
+	}
+
+	stop := make(chan struct{})
+	go w.heartbeat(jobCtx, job, stop)
+	err := w.handler(jobCtx, job)
+	close(stop)
+
+	switch {
+	case err == nil:
+		w.complete(jobCtx, job)
+	case job.Attempts >= w.cfg.MaxAttempts:
+		w.bury(jobCtx, job, err.Error())
+	default:
+		w.retry(jobCtx, job, err)
+	}
+}
+
+// heartbeat extends the visibility timeout of job until stop is closed.
+func (w *JobWorker) heartbeat(ctx *context.Context, job *Job, stop <-chan struct{}) {
+	ticker := time.NewTicker(w.cfg.VisibilityTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			deadline := time.Now().Add(w.cfg.VisibilityTimeout).UnixMilli()
+			err := w.cache.rDB.ZAddXX(ctx.Context, w.keys.active, redis.Z{Score: float64(deadline), Member: job.ID}).Err()
+			if err != nil {
+				w.logger.Warn("Failed to extend job visibility timeout",
+					zap.String("queue", w.cfg.Queue),
+					zap.String("id", job.ID),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+func (w *JobWorker) complete(ctx *context.Context, job *Job) {
+	_, err := w.cache.rDB.TxPipelined(ctx.Context, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx.Context, w.keys.active, job.ID)
+		pipe.Del(ctx.Context, w.keys.job+job.ID)
+		return nil
+	})
+	if err != nil {
+		w.logger.Error("Failed to complete job",
+			zap.String("queue", w.cfg.Queue),
+			zap.String("id", job.ID),
+			zap.Any(enums.RequestId, job.RequestId),
+			zap.Error(err),
+		)
+	}
+}
+
+func (w *JobWorker) retry(ctx *context.Context, job *Job, cause error) {
+	delay := w.retryDelay(job.Attempts)
+	runAt := time.Now().Add(delay).UnixMilli()
+	_, err := w.cache.rDB.TxPipelined(ctx.Context, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx.Context, w.keys.active, job.ID)
+		pipe.HSet(ctx.Context, w.keys.job+job.ID, "error", cause.Error())
+		pipe.ZAdd(ctx.Context, w.keys.delayed, redis.Z{Score: float64(runAt), Member: job.ID})
+		return nil
+	})
+	if err != nil {
+		w.logger.Error("Failed to schedule job retry",
+			zap.String("queue", w.cfg.Queue),
+			zap.String("id", job.ID),
+			zap.Any(enums.RequestId, job.RequestId),
+			zap.Error(err),
+		)
+		return
+	}
+	w.logger.Warn("Job failed, retrying",
+		zap.String("queue", w.cfg.Queue),
+		zap.String("id", job.ID),
+		zap.Int("attempts", job.Attempts),
+		zap.Duration("retryIn", delay),
+		zap.Any(enums.RequestId, job.RequestId),
+		zap.Error(cause),
+	)
+}
+
+// bury moves job to the dead queue.
+func (w *JobWorker) bury(ctx *context.Context, job *Job, reason string) {
+	_, err := w.cache.rDB.TxPipelined(ctx.Context, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx.Context, w.keys.active, job.ID)
+		pipe.HSet(ctx.Context, w.keys.job+job.ID, "error", reason)
+		pipe.LPush(ctx.Context, w.keys.dead, job.ID)
+		return nil
+	})
+	if err != nil {
+		w.logger.Error("Failed to move job to dead queue",
+			zap.String("queue", w.cfg.Queue),
+			zap.String("id", job.ID),
+			zap.Any(enums.RequestId, job.RequestId),
+			zap.Error(err),
+		)
+		return
+	}
+	w.logger.Error("Job moved to dead queue",
+		zap.String("queue", w.cfg.Queue),
+		zap.String("id", job.ID),
+		zap.Int("attempts", job.Attempts),
+		zap.String("error", reason),
+		zap.Any(enums.RequestId, job.RequestId),
+	)
+}
+
+func (w *JobWorker) retryDelay(attempts int) time.Duration {
+	delay := w.cfg.BackoffMin
+	for i := 1; i < attempts && delay < w.cfg.BackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, w.cfg.BackoffMax)
+}
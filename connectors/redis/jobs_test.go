@@ -0,0 +1,126 @@
+package redis_test
+
+import (
+	"github.com/NitinD97/common-utils/connectors/redis"
+	"github.com/NitinD97/common-utils/connectors/redis/redistest"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/NitinD97/common-utils/errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newJobWorkerConfig() redis.JobWorkerConfig {
+	return redis.JobWorkerConfig{
+		Queue:        "emails",
+		MaxAttempts:  2,
+		PollInterval: 5 * time.Millisecond,
+		BackoffMin:   10 * time.Millisecond,
+		BackoffMax:   10 * time.Millisecond,
+	}
+}
+
+// jobRecorder records the attempts of the jobs it runs, failing those below
+// succeedAt.
+type jobRecorder struct {
+	mutex     sync.Mutex
+	jobs      []redis.Job
+	succeedAt int
+}
+
+func (r *jobRecorder) handle(ctx *context.Context, job *redis.Job) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.jobs = append(r.jobs, *job)
+	if job.Attempts < r.succeedAt {
+		return errors.New("smtp unavailable")
+	}
+	return nil
+}
+
+func (r *jobRecorder) attempts() []int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	attempts := make([]int, len(r.jobs))
+	for i, job := range r.jobs {
+		attempts[i] = job.Attempts
+	}
+	return attempts
+}
+
+func TestJobWorkerRunsJob(t *testing.T) {
+	cache, server := redistest.NewCache(t)
+	ctx := context.NewContext()
+	ctx.Set(enums.RequestId, "request-1")
+	id, err := redis.NewJobQueue(cache).Enqueue(ctx, "emails", order{Id: 42}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &jobRecorder{}
+	runUntilCleanup(t, redis.NewJobWorker(cache, newJobWorkerConfig(), recorder.handle, nil).Run)
+
+	eventually(t, func() bool { return len(recorder.attempts()) == 1 }, "the job did not run")
+	recorder.mutex.Lock()
+	job := recorder.jobs[0]
+	recorder.mutex.Unlock()
+	var payload order
+	if err := job.Decode(&payload); err != nil || payload.Id != 42 || job.ID != id || job.RequestId != "request-1" {
+		t.Fatalf("unexpected job %+v, %v", job, err)
+	}
+	eventually(t, func() bool { return !server.Exists("jobs:{emails}:job:" + id) }, "the completed job was not deleted")
+}
+
+func TestJobWorkerRetriesFailedJob(t *testing.T) {
+	cache, _ := redistest.NewCache(t)
+	if _, err := redis.NewJobQueue(cache).Enqueue(context.NewContext(), "emails", order{Id: 42}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &jobRecorder{succeedAt: 2}
+	runUntilCleanup(t, redis.NewJobWorker(cache, newJobWorkerConfig(), recorder.handle, nil).Run)
+
+	eventually(t, func() bool { return len(recorder.attempts()) == 2 }, "the failed job was not retried")
+	time.Sleep(50 * time.Millisecond)
+	if attempts := recorder.attempts(); len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Fatalf("unexpected attempts %v", attempts)
+	}
+}
+
+func TestJobWorkerBuriesExhaustedJob(t *testing.T) {
+	cache, _ := redistest.NewCache(t)
+	queue := redis.NewJobQueue(cache)
+	ctx := context.NewContext()
+	id, err := queue.Enqueue(ctx, "emails", order{Id: 42}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &jobRecorder{succeedAt: 3}
+	runUntilCleanup(t, redis.NewJobWorker(cache, newJobWorkerConfig(), recorder.handle, nil).Run)
+
+	var dead []*redis.Job
+	eventually(t, func() bool {
+		dead, err = queue.DeadJobs(ctx, "emails", 10)
+		return err == nil && len(dead) == 1
+	}, "the job was not moved to the dead queue")
+	if dead[0].ID != id || dead[0].Attempts != 2 || dead[0].Error != "smtp unavailable" {
+		t.Fatalf("unexpected dead job %+v", dead[0])
+	}
+
+	// A requeued job starts over and succeeds on its third run overall.
+	if err := queue.RetryDeadJob(ctx, "emails", id); err != nil {
+		t.Fatal(err)
+	}
+	recorder.mutex.Lock()
+	recorder.succeedAt = 1
+	recorder.mutex.Unlock()
+	eventually(t, func() bool { return len(recorder.attempts()) == 3 }, "the requeued job did not run")
+	if attempts := recorder.attempts(); attempts[2] != 1 {
+		t.Fatalf("expected the attempts of the requeued job to restart, got %v", attempts)
+	}
+	if err := queue.RetryDeadJob(ctx, "emails", id); !errors.Is(err, redis.ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
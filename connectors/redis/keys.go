@@ -0,0 +1,124 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/NitinD97/common-utils/configuration"
+	"github.com/NitinD97/common-utils/enums"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keySeparator joins the parts of keys built by a KeyBuilder.
+const keySeparator = ":"
+
+// partEscaper escapes the separator and hash tag braces inside key parts, so
+// ("a:b", "c") and ("a", "b:c") never produce the same key.
+var partEscaper = strings.NewReplacer("%", "%25", ":", "%3A", "{", "%7B", "}", "%7D")
+
+// globEscaper escapes the characters SCAN and KEYS patterns treat as wildcards.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// KeyBuilder composes keys prefixed with the environment and the service name,
+// so services sharing a Redis DB cannot collide.
+type KeyBuilder struct {
+	prefix string
+}
+
+func NewKeyBuilder(environment string, serviceName string) *KeyBuilder {
+	return &KeyBuilder{prefix: joinParts([]any{environment, serviceName})}
+}
+
+// NewKeyBuilderFromConfig reads the environment and the service name from the
+// "environment" and "serviceName" keys of the loaded configuration. Both are
+// empty if no configuration was loaded.
+func NewKeyBuilderFromConfig() *KeyBuilder {
+	var environment, serviceName string
+	if config := configuration.GetConfig(); config != nil {
+		environment = config.GetString("environment")
+		serviceName = config.GetString(enums.ServiceName)
+	}
+	return NewKeyBuilder(environment, serviceName)
+}
+
+// Key returns the unversioned key made of parts, e.g. Key("lock", orderId) is
+// "production:orders:lock:42".
+func (b *KeyBuilder) Key(parts ...any) string {
+	return b.prefix + keySeparator + joinParts(parts)
+}
+
+// Schema returns the keys of entity at version. Bump version when the cached
+// representation of entity changes: readers of the new version never see
+// entries written in the old layout, which expire on their own.
+func (b *KeyBuilder) Schema(entity string, version int) *KeySchema {
+	return &KeySchema{
+		prefix:  b.Key(entity, "v"+strconv.Itoa(version)),
+		version: version,
+	}
+}
+
+// KeySchema builds the keys of one versioned entity.
+type KeySchema struct {
+	prefix  string
+	version int
+}
+
+// Key returns the key of the entry identified by parts, e.g. Key(userId) is
+// "production:orders:user:v3:42".
+func (s *KeySchema) Key(parts ...any) string {
+	if len(parts) == 0 {
+		return s.prefix
+	}
+	return s.prefix + keySeparator + joinParts(parts)
+}
+
+// Pattern matches every key of the schema version, for SCAN. Wildcards in the
+// parts of the prefix match only themselves.
+func (s *KeySchema) Pattern() string {
+	return globEscaper.Replace(s.prefix) + keySeparator + "*"
+}
+
+func (s *KeySchema) Version() int {
+	return s.version
+}
+
+// HashTag is a key part kept between braces, so keys sharing it land in the
+// same cluster hash slot.
+type HashTag string
+
+func joinParts(parts []any) string {
+	formatted := make([]string, len(parts))
+	for i, part := range parts {
+		formatted[i] = formatPart(part)
+	}
+	return strings.Join(formatted, keySeparator)
+}
+
+func formatPart(part any) string {
+	switch p := part.(type) {
+	case HashTag:
+		return "{" + partEscaper.Replace(string(p)) + "}"
+	case string:
+		return partEscaper.Replace(p)
+	case int:
+		return strconv.Itoa(p)
+	case int32:
+		return strconv.FormatInt(int64(p), 10)
+	case int64:
+		return strconv.FormatInt(p, 10)
+	case uint:
+		return strconv.FormatUint(uint64(p), 10)
+	case uint32:
+		return strconv.FormatUint(uint64(p), 10)
+	case uint64:
+		return strconv.FormatUint(p, 10)
+	case bool:
+		return strconv.FormatBool(p)
+	case time.Time:
+		return strconv.FormatInt(p.Unix(), 10)
+	case fmt.Stringer:
+		return partEscaper.Replace(p.String())
+	default:
+		return partEscaper.Replace(fmt.Sprint(p))
+	}
+}
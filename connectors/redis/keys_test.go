@@ -0,0 +1,30 @@
+package redis_test
+
+import (
+	stdcontext "context"
+	"github.com/NitinD97/common-utils/connectors/redis"
+	"github.com/NitinD97/common-utils/connectors/redis/redistest"
+	"testing"
+)
+
+func TestKeySchemaPatternEscapesWildcards(t *testing.T) {
+	cache, server := redistest.NewCache(t)
+	schema := redis.NewKeyBuilder("test", "orders").Schema("user*", 1)
+	server.Set(schema.Key(42), "a")
+	server.Set(redis.NewKeyBuilder("test", "orders").Schema("users", 1).Key(42), "b")
+
+	result, err := cache.Connector().Do(stdcontext.Background(), "SCAN", nil, []string{"0", "MATCH", schema.Pattern(), "COUNT", "100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := result.([]any)[1].([]any)
+	if len(keys) != 1 || keys[0] != schema.Key(42) {
+		t.Fatalf("pattern %s matched %v", schema.Pattern(), keys)
+	}
+}
+
+func TestNewKeyBuilderFromConfigWithoutConfig(t *testing.T) {
+	if key := redis.NewKeyBuilderFromConfig().Key("lock", 42); key != "::lock:42" {
+		t.Fatalf("got %s", key)
+	}
+}
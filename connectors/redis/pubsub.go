@@ -0,0 +1,151 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/goccy/go-json"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// envelope is the wire format of messages sent through Publish.
+type envelope struct {
+	RequestId string          `json:"requestId,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// Message is a pub/sub message handed to a MessageHandler.
+type Message struct {
+	Channel string
+	// Pattern is the pattern that matched Channel, for pattern subscriptions.
+	Pattern   string
+	RequestId string
+	Payload   json.RawMessage
+}
+
+// Decode unmarshals the JSON payload of the message into value.
+func (m *Message) Decode(value interface{}) error {
+	return json.Unmarshal(m.Payload, value)
+}
+
+// MessageHandler processes a single pub/sub message. Pub/sub has no
+// redelivery, so a returned error is only logged.
+type MessageHandler func(ctx *context.Context, msg *Message) error
+
+// TypedHandler adapts a handler taking a decoded payload to a MessageHandler.
+func TypedHandler[T any](handler func(ctx *context.Context, channel string, payload T) error) MessageHandler {
+	return func(ctx *context.Context, msg *Message) error {
+		var payload T
+		if err := msg.Decode(&payload); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to decode message on channel %s", msg.Channel))
+		}
+		return handler(ctx, msg.Channel, payload)
+	}
+}
+
+// Publish sends msg, JSON encoded together with the request ID carried by
+// ctx, to channel.
+func (cache *Cache) Publish(ctx *context.Context, channel string, msg interface{}) error {
+	if err := cache.requireGoRedis(); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode message")
+	}
+	bytes, err := json.Marshal(envelope{RequestId: requestId(ctx), Payload: payload})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode message")
+	}
+	err = cache.rDB.Publish(ctx.Context, channel, bytes).Err()
+	return errors.Wrap(err, fmt.Sprintf("failed to publish to channel %s", channel))
+}
+
+// Subscribe handles messages published to channels until ctx is cancelled.
+// Subscriptions are restored automatically after the connection drops.
+func (cache *Cache) Subscribe(ctx *context.Context, channels []string, handler MessageHandler) error {
+	if err := cache.requireGoRedis(); err != nil {
+		return err
+	}
+	return cache.subscribe(ctx, cache.rDB.Subscribe(ctx.Context, channels...), channels, handler)
+}
+
+// PSubscribe is like Subscribe but takes glob-style channel patterns.
+func (cache *Cache) PSubscribe(ctx *context.Context, patterns []string, handler MessageHandler) error {
+	if err := cache.requireGoRedis(); err != nil {
+		return err
+	}
+	return cache.subscribe(ctx, cache.rDB.PSubscribe(ctx.Context, patterns...), patterns, handler)
+}
+
+func (cache *Cache) subscribe(ctx *context.Context, pubSub *redis.PubSub, channels []string, handler MessageHandler) error {
+	defer pubSub.Close()
+
+	// Receiving does not observe ctx, closing the subscription unblocks it.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Context.Done():
+			_ = pubSub.Close()
+		case <-stop:
+		}
+	}()
+
+	// Wait for the subscription to be confirmed so a bad connection fails fast.
+	if _, err := pubSub.Receive(ctx.Context); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to subscribe to %s", strings.Join(channels, ", ")))
+	}
+
+	backoff := newBackoff(100*time.Millisecond, 10*time.Second)
+	for {
+		msg, err := pubSub.ReceiveMessage(ctx.Context)
+		if err != nil {
+			if ctx.Context.Err() != nil {
+				return nil
+			}
+			// go-redis reconnects and resubscribes on the next receive.
+			cache.logger.Warn("Pub/sub connection failed, resubscribing",
+				zap.Strings("channels", channels),
+				zap.Error(err),
+			)
+			if !sleepContext(ctx, backoff.next()) {
+				return nil
+			}
+			continue
+		}
+		backoff.reset()
+		cache.handleMessage(ctx, msg, handler)
+	}
+}
+
+func (cache *Cache) handleMessage(ctx *context.Context, msg *redis.Message, handler MessageHandler) {
+	message := &Message{
+		Channel: msg.Channel,
+		Pattern: msg.Pattern,
+	}
+	var env envelope
+	if err := json.Unmarshal([]byte(msg.Payload), &env); err == nil && env.Payload != nil {
+		message.RequestId = env.RequestId
+		message.Payload = env.Payload
+	} else {
+		// Not published through Publish, hand over the raw payload.
+		message.Payload = json.RawMessage(msg.Payload)
+	}
+
+	msgCtx := ctx.Clone()
+	if message.RequestId != "" {
+		msgCtx.Set(enums.RequestId, message.RequestId)
+	}
+	if err := handler(msgCtx, message); err != nil {
+		cache.logger.Error("Pub/sub handler failed",
+			zap.String("channel", message.Channel),
+			zap.Any(enums.RequestId, message.RequestId),
+			zap.Error(err),
+		)
+	}
+}
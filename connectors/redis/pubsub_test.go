@@ -0,0 +1,78 @@
+package redis_test
+
+import (
+	"github.com/NitinD97/common-utils/connectors/redis"
+	"github.com/NitinD97/common-utils/connectors/redis/redistest"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/enums"
+	"testing"
+	"time"
+)
+
+// publishUntilReceived publishes msg to channel until it shows up on
+// received, since a subscription only gets the messages published after it
+// was confirmed.
+func publishUntilReceived(t *testing.T, cache *redis.Cache, channel string, msg order, received <-chan *redis.Message) *redis.Message {
+	t.Helper()
+	ctx := context.NewContext()
+	ctx.Set(enums.RequestId, "request-1")
+	deadline := time.After(5 * time.Second)
+	for {
+		if err := cache.Publish(ctx, channel, msg); err != nil {
+			t.Logf("publish failed: %v", err)
+		}
+		select {
+		case message := <-received:
+			return message
+		case <-time.After(20 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("no message received on %s", channel)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	cache, _ := redistest.NewCache(t)
+	received := make(chan *redis.Message, 100)
+	runUntilCleanup(t, func(ctx *context.Context) error {
+		return cache.PSubscribe(ctx, []string{"orders.*"}, func(ctx *context.Context, msg *redis.Message) error {
+			received <- msg
+			return nil
+		})
+	})
+
+	msg := publishUntilReceived(t, cache, "orders.created", order{Id: 42}, received)
+	var payload order
+	if err := msg.Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Id != 42 || msg.Channel != "orders.created" || msg.Pattern != "orders.*" || msg.RequestId != "request-1" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+}
+
+func TestSubscribeResubscribesAfterConnectionDrops(t *testing.T) {
+	cache, server := redistest.NewCache(t)
+	received := make(chan *redis.Message, 100)
+	runUntilCleanup(t, func(ctx *context.Context) error {
+		return cache.Subscribe(ctx, []string{"orders"}, func(ctx *context.Context, msg *redis.Message) error {
+			received <- msg
+			return nil
+		})
+	})
+	publishUntilReceived(t, cache, "orders", order{Id: 1}, received)
+
+	// Restarting drops every connection along with its subscriptions.
+	server.Close()
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	for len(received) > 0 {
+		<-received
+	}
+	msg := publishUntilReceived(t, cache, "orders", order{Id: 2}, received)
+	var payload order
+	if err := msg.Decode(&payload); err != nil || payload.Id != 2 {
+		t.Fatalf("unexpected message %+v, %v", msg, err)
+	}
+}
@@ -1,83 +1,202 @@
 package redis
 
 import (
+	stdcontext "context"
 	"fmt"
 	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/enums"
 	"github.com/NitinD97/common-utils/errors"
+	"github.com/NitinD97/common-utils/shutdown"
 	"github.com/goccy/go-json"
 	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
 	"strconv"
 	"time"
 )
 
 type Cache struct {
-	rDB *redis.Client
+	conn Connector
+	// rDB is the go-redis client behind conn, nil on other drivers.
+	rDB      redis.UniversalClient
+	codecs   []Codec
+	logger   *zap.Logger
+	counters cacheCounters
 }
 
-func NewRedisCache(cfg Config) *Cache {
-	return &Cache{
-		rDB: redis.NewClient(&redis.Options{
-			Addr:     cfg.Host + ":" + strconv.Itoa(cfg.Port),
-			Password: cfg.Password,
-			DB:       cfg.Db,
-			PoolSize: cfg.PoolSize,
-		})}
+type CacheOption func(*Cache)
+
+// WithLogger sets the logger used by background work started from the Cache,
+// such as subscriptions.
+func WithLogger(logger *zap.Logger) CacheOption {
+	return func(c *Cache) {
+		c.logger = logger
+	}
 }
 
-func (cache *Cache) Ping(ctx *context.Context) error {
-	result, err := cache.rDB.Ping(ctx.Context).Result()
+// NewCache creates a Cache on the driver selected by cfg.Driver, encoding
+// values with the codecs configured in cfg unless WithCodecs overrides them.
+func NewCache(cfg Config, opts ...CacheOption) (*Cache, error) {
+	conn, err := NewConnector(cfg)
 	if err != nil {
-		return errors.Wrap(err, "failed to ping redis")
+		return nil, err
+	}
+	codecs, err := cfg.codecs()
+	if err != nil {
+		return nil, err
+	}
+	return NewCacheWithConnector(conn, append([]CacheOption{WithCodecs(codecs...)}, opts...)...), nil
+}
+
+// NewRedisCache is NewCache for callers written before it could fail. It
+// panics if cfg is invalid, e.g. names an unknown codec.
+//
+// Deprecated: use NewCache, which returns the error.
+func NewRedisCache(cfg Config, opts ...CacheOption) *Cache {
+	cache, err := NewCache(cfg, opts...)
+	if err != nil {
+		panic(fmt.Errorf("unable to create redis cache\n %w", err))
+	}
+	return cache
+}
+
+// WithShutdown disconnects the Cache when manager shuts down.
+func WithShutdown(manager *shutdown.Manager) CacheOption {
+	return func(c *Cache) {
+		manager.Register("redis", shutdown.CloserFunc(func(stdcontext.Context) error {
+			return c.Disconnect()
+		}))
 	}
-	if result != "PONG" {
-		return errors.New("redis ping failed")
+}
+
+func NewCacheWithConnector(conn Connector, opts ...CacheOption) *Cache {
+	cache := &Cache{
+		conn:   conn,
+		logger: zap.NewNop(),
+	}
+	if c, ok := conn.(*goRedisConnector); ok {
+		cache.rDB = c.client
+	}
+	for _, opt := range opts {
+		opt(cache)
+	}
+	return cache
+}
+
+// Connector returns the driver the Cache runs on.
+func (cache *Cache) Connector() Connector {
+	return cache.conn
+}
+
+func (cache *Cache) Ping(ctx *context.Context) error {
+	if err := cache.conn.Ping(ctx.Context); err != nil {
+		return errors.Wrap(err, "failed to ping redis")
 	}
 	return nil
 }
 
 func (cache *Cache) Disconnect() error {
-	if cache.rDB != nil {
-		return cache.rDB.Close()
+	if cache.conn != nil {
+		return cache.conn.Close()
 	}
 	return nil
 }
 
-func (cache *Cache) SetJson(ctx *context.Context, key string, value interface{}, expiration time.Duration) error {
+// SetJson stores value JSON encoded. The entry is registered under tags, see
+// InvalidateTags.
+func (cache *Cache) SetJson(ctx *context.Context, key string, value interface{}, expiration time.Duration, tags ...string) error {
 	bytes, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
-	result := cache.rDB.Set(ctx.Context, key, bytes, expiration)
-	return result.Err()
+	if bytes, err = cache.encode(key, bytes); err != nil {
+		return err
+	}
+	return cache.setWithTags(ctx, key, string(bytes), expiration, tags)
 }
 
-func (cache *Cache) Set(ctx *context.Context, key string, value string, expiration time.Duration) error {
-	result := cache.rDB.Set(ctx.Context, key, value, expiration)
-	return errors.Wrap(result.Err(), fmt.Sprintf("failed to set key %s", key))
+// Set stores value. The entry is registered under tags, see InvalidateTags.
+func (cache *Cache) Set(ctx *context.Context, key string, value string, expiration time.Duration, tags ...string) error {
+	bytes, err := cache.encode(key, []byte(value))
+	if err == nil {
+		err = cache.setWithTags(ctx, key, string(bytes), expiration, tags)
+	}
+	return errors.Wrap(err, fmt.Sprintf("failed to set key %s", key))
 }
 
 func (cache *Cache) Get(ctx *context.Context, key string) (string, error) {
-	result, err := cache.rDB.Get(ctx.Context, key).Result()
-	switch {
-	case errors.Is(err, redis.Nil):
-		return "", ErrKeyNotFound
-	case err != nil:
+	result, err := cache.conn.Get(ctx.Context, key)
+	if err == nil && result == "" {
+		err = ErrKeyNotFound
+	}
+	cache.counters.record(err)
+	if err != nil {
 		return "", err
-	case result == "":
-		return "", ErrKeyNotFound
 	}
-	return result, nil
+	decoded, err := cache.decode(key, []byte(result))
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
 }
 
+// GetJSON decodes the value stored by SetJson into value. Values written
+// through codecs are detected by their envelope and decoded first.
 func (cache *Cache) GetJSON(ctx *context.Context, key string, value interface{}) error {
-	result := cache.rDB.Get(ctx.Context, key)
-	storedBytes, err := result.Bytes()
+	result, err := cache.conn.Get(ctx.Context, key)
+	cache.counters.record(err)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(storedBytes, &value)
+	decoded, err := cache.decode(key, []byte(result))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, &value)
 }
 
 func (cache *Cache) Delete(ctx *context.Context, key string) error {
-	return cache.rDB.Del(ctx.Context, key).Err()
+	return cache.conn.Del(ctx.Context, key)
+}
+
+// requireGoRedis reports ErrUnsupportedDriver unless the Cache runs on go-redis.
+func (cache *Cache) requireGoRedis() error {
+	if cache.rDB == nil {
+		return ErrUnsupportedDriver
+	}
+	return nil
+}
+
+// ceilMilliseconds converts d for PX and PEXPIRE, rounding it up so a positive
+// duration never becomes 0, which means no expiry.
+func ceilMilliseconds(d time.Duration) int64 {
+	ms := d.Milliseconds()
+	if d > 0 && time.Duration(ms)*time.Millisecond < d {
+		ms++
+	}
+	return ms
+}
+
+// milliseconds formats d as a script argument, see ceilMilliseconds.
+func milliseconds(d time.Duration) string {
+	return strconv.FormatInt(ceilMilliseconds(d), 10)
+}
+
+// int64Reply returns the integer reply of a script or command.
+func int64Reply(result any) (int64, error) {
+	value, ok := result.(int64)
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("unexpected redis reply %v (%T)", result, result))
+	}
+	return value, nil
+}
+
+// requestId returns the request ID carried by ctx, if any.
+func requestId(ctx *context.Context) string {
+	if id, ok := ctx.Get(enums.RequestId).(string); ok {
+		return id
+	}
+	if id, ok := ctx.Context.Value(enums.RequestId).(string); ok {
+		return id
+	}
+	return ""
 }
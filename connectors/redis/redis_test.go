@@ -0,0 +1,202 @@
+package redis_test
+
+import (
+	"github.com/NitinD97/common-utils/connectors/redis"
+	"github.com/NitinD97/common-utils/connectors/redis/redistest"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/errors"
+	"testing"
+	"time"
+)
+
+// drivers returns a Cache per driver on a fresh Server.
+func drivers(opts ...redis.CacheOption) map[string]func(t *testing.T) (*redis.Cache, *redistest.Server) {
+	return map[string]func(t *testing.T) (*redis.Cache, *redistest.Server){
+		redis.DriverGoRedis: func(t *testing.T) (*redis.Cache, *redistest.Server) {
+			server := redistest.Run(t)
+			return server.NewCache(t, opts...), server
+		},
+		redis.DriverRueidis: func(t *testing.T) (*redis.Cache, *redistest.Server) {
+			server := redistest.Run(t)
+			return server.NewRueidisCache(t, opts...), server
+		},
+	}
+}
+
+func TestCacheSetGet(t *testing.T) {
+	for driver, newCache := range drivers() {
+		t.Run(driver, func(t *testing.T) {
+			cache, server := newCache(t)
+			ctx := context.NewContext()
+
+			if _, err := cache.Get(ctx, "missing"); !errors.Is(err, redis.ErrKeyNotFound) {
+				t.Fatalf("expected ErrKeyNotFound, got %v", err)
+			}
+			if err := cache.Set(ctx, "greeting", "hello", time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			value, err := cache.Get(ctx, "greeting")
+			if err != nil || value != "hello" {
+				t.Fatalf("got %q, %v", value, err)
+			}
+
+			server.FastForward(2 * time.Minute)
+			if _, err := cache.Get(ctx, "greeting"); !errors.Is(err, redis.ErrKeyNotFound) {
+				t.Fatalf("expected the entry to expire, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCacheJSONWithCodecs(t *testing.T) {
+	compression, err := redis.NewCompressionCodec(redis.CompressionZstd, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryption, err := redis.NewAESGCMCodec("k1", map[string]string{"k1": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type profile struct {
+		Name string `json:"name"`
+		Bio  string `json:"bio"`
+	}
+
+	for driver, newCache := range drivers(redis.WithCodecs(compression, encryption)) {
+		t.Run(driver, func(t *testing.T) {
+			cache, server := newCache(t)
+			ctx := context.NewContext()
+
+			for _, want := range []profile{{Name: "a"}, {Name: "b", Bio: "a bio long enough to be compressed"}} {
+				if err := cache.SetJson(ctx, "profile", want, time.Minute); err != nil {
+					t.Fatal(err)
+				}
+				stored, err := server.Get("profile")
+				if err != nil || stored[0] != 0 {
+					t.Fatalf("expected an envelope, got %q, %v", stored, err)
+				}
+				var got profile
+				if err := cache.GetJSON(ctx, "profile", &got); err != nil || got != want {
+					t.Fatalf("got %+v, %v", got, err)
+				}
+			}
+
+			// Written before the codecs were enabled.
+			if err := server.Set("legacy", "plain"); err != nil {
+				t.Fatal(err)
+			}
+			if value, err := cache.Get(ctx, "legacy"); err != nil || value != "plain" {
+				t.Fatalf("got %q, %v", value, err)
+			}
+		})
+	}
+}
+
+func TestCacheInvalidateTags(t *testing.T) {
+	for driver, newCache := range drivers() {
+		t.Run(driver, func(t *testing.T) {
+			cache, _ := newCache(t)
+			ctx := context.NewContext()
+
+			if err := cache.Set(ctx, "user:1", "a", time.Minute, "users"); err != nil {
+				t.Fatal(err)
+			}
+			if err := cache.Set(ctx, "order:1", "b", time.Minute, "orders"); err != nil {
+				t.Fatal(err)
+			}
+			if err := cache.InvalidateTags(ctx, "users"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cache.Get(ctx, "user:1"); !errors.Is(err, redis.ErrKeyNotFound) {
+				t.Fatalf("expected user:1 to be invalidated, got %v", err)
+			}
+			if _, err := cache.Get(ctx, "order:1"); err != nil {
+				t.Fatalf("expected order:1 to be kept, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCacheIncr(t *testing.T) {
+	for driver, newCache := range drivers() {
+		t.Run(driver, func(t *testing.T) {
+			cache, server := newCache(t)
+			ctx := context.NewContext()
+
+			for want := int64(1); want <= 3; want++ {
+				got, err := cache.Incr(ctx, "hits", time.Minute)
+				if err != nil || got != want {
+					t.Fatalf("got %d, %v, want %d", got, err, want)
+				}
+			}
+			if ttl := server.TTL("hits"); ttl <= 0 || ttl > time.Minute {
+				t.Fatalf("unexpected ttl %v", ttl)
+			}
+		})
+	}
+}
+
+func TestCacheExpiration(t *testing.T) {
+	for driver, newCache := range drivers() {
+		t.Run(driver, func(t *testing.T) {
+			cache, server := newCache(t)
+			ctx := context.NewContext()
+
+			for _, tags := range [][]string{nil, {"users"}} {
+				if err := cache.Set(ctx, "user:1", "a", 500*time.Microsecond, tags...); err != nil {
+					t.Fatal(err)
+				}
+				if ttl := server.TTL("user:1"); ttl != time.Millisecond {
+					t.Fatalf("tags %v: expected the ttl to round up to 1ms, got %v", tags, ttl)
+				}
+
+				if err := cache.Set(ctx, "user:1", "a", time.Minute, tags...); err != nil {
+					t.Fatal(err)
+				}
+				if err := cache.Set(ctx, "user:1", "b", redis.KeepTTL, tags...); err != nil {
+					t.Fatal(err)
+				}
+				if ttl := server.TTL("user:1"); ttl != time.Minute {
+					t.Fatalf("tags %v: expected the ttl to be kept, got %v", tags, ttl)
+				}
+				if value, err := cache.Get(ctx, "user:1"); err != nil || value != "b" {
+					t.Fatalf("got %q, %v", value, err)
+				}
+			}
+			if ttl := server.TTL("tag:users"); ttl != time.Minute {
+				t.Fatalf("expected the tag set to expire with its entry, got %v", ttl)
+			}
+		})
+	}
+}
+
+func TestCacheEncryptedValueIsBoundToItsKey(t *testing.T) {
+	encryption, err := redis.NewAESGCMCodec("k1", map[string]string{"k1": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for driver, newCache := range drivers(redis.WithCodecs(encryption)) {
+		t.Run(driver, func(t *testing.T) {
+			cache, server := newCache(t)
+			ctx := context.NewContext()
+
+			if err := cache.Set(ctx, "user:1:role", "admin", time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := server.Get("user:1:role")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := server.Set("user:2:role", stored); err != nil {
+				t.Fatal(err)
+			}
+			if value, err := cache.Get(ctx, "user:2:role"); err == nil {
+				t.Fatalf("expected the copied value to fail to decrypt, got %q", value)
+			}
+			if value, err := cache.Get(ctx, "user:1:role"); err != nil || value != "admin" {
+				t.Fatalf("got %q, %v", value, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Package redistest runs an in-process Redis stand-in for tests of code built
+// on connectors/redis and rate_limiter, so they do not need a Redis server.
+package redistest
+
+import (
+	"github.com/NitinD97/common-utils/connectors/redis"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/rueidis"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Server is an in-process RESP server implementing the commands used by the
+// connectors: strings with expiry, sets, sorted sets, hashes, lists, streams,
+// HyperLogLogs, EVAL/EVALSHA, TIME and pub/sub. Its clock is frozen at the
+// time it started and only advances through FastForward, which moves both
+// key expiry and TIME, so expiry and rate limits are deterministic.
+type Server struct {
+	*miniredis.Miniredis
+	mutex sync.Mutex
+	now   time.Time
+}
+
+// Start starts a Server on a random local port. Close stops it.
+func Start() (*Server, error) {
+	server, err := miniredis.Run()
+	if err != nil {
+		return nil, err
+	}
+	return newServer(server), nil
+}
+
+// Run starts a Server that is stopped when tb finishes.
+func Run(tb testing.TB) *Server {
+	tb.Helper()
+	return newServer(miniredis.RunT(tb))
+}
+
+func newServer(m *miniredis.Miniredis) *Server {
+	server := &Server{Miniredis: m}
+	server.SetTime(time.Now())
+	return server
+}
+
+// SetTime sets the time returned by TIME, which the rate limiter scripts run
+// on. It does not expire keys, see FastForward.
+func (s *Server) SetTime(t time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.now = t
+	s.Miniredis.SetTime(t)
+}
+
+// FastForward advances the clock of the Server by duration, expiring the keys
+// whose TTL elapses.
+func (s *Server) FastForward(duration time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.now = s.now.Add(duration)
+	s.Miniredis.SetTime(s.now)
+	s.Miniredis.FastForward(duration)
+}
+
+// Now returns the time of the Server.
+func (s *Server) Now() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.now
+}
+
+// Config returns the connector config of the Server on driver.
+func (s *Server) Config(driver string) redis.Config {
+	return redis.Config{
+		Driver: driver,
+		Host:   s.Host(),
+		Port:   s.Server().Addr().Port,
+		// The server does not implement CLIENT TRACKING.
+		DisableClientCache: true,
+	}
+}
+
+// NewCache returns a go-redis Cache on the Server, disconnected when tb
+// finishes.
+func (s *Server) NewCache(tb testing.TB, opts ...redis.CacheOption) *redis.Cache {
+	return s.newCache(tb, redis.DriverGoRedis, opts)
+}
+
+// NewRueidisCache is like NewCache on the rueidis driver.
+func (s *Server) NewRueidisCache(tb testing.TB, opts ...redis.CacheOption) *redis.Cache {
+	return s.newCache(tb, redis.DriverRueidis, opts)
+}
+
+func (s *Server) newCache(tb testing.TB, driver string, opts []redis.CacheOption) *redis.Cache {
+	tb.Helper()
+	cache, err := redis.NewCache(s.Config(driver), opts...)
+	if err != nil {
+		tb.Fatalf("failed to create cache: %v", err)
+	}
+	tb.Cleanup(func() {
+		_ = cache.Disconnect()
+	})
+	return cache
+}
+
+// NewRueidisClient returns a rueidis client on the Server, e.g. for
+// rate_limiter.NewLimiter, closed when tb finishes.
+func (s *Server) NewRueidisClient(tb testing.TB) rueidis.Client {
+	tb.Helper()
+	client, err := redis.NewRueidisClient(s.Config(redis.DriverRueidis))
+	if err != nil {
+		tb.Fatalf("failed to create rueidis client: %v", err)
+	}
+	tb.Cleanup(client.Close)
+	return client
+}
+
+// NewCache starts a Server for tb and returns a go-redis Cache on it.
+func NewCache(tb testing.TB, opts ...redis.CacheOption) (*redis.Cache, *Server) {
+	tb.Helper()
+	server := Run(tb)
+	return server.NewCache(tb, opts...), server
+}
+
+// NewRueidisClient starts a Server for tb and returns a rueidis client on it.
+func NewRueidisClient(tb testing.TB) (rueidis.Client, *Server) {
+	tb.Helper()
+	server := Run(tb)
+	return server.NewRueidisClient(tb), server
+}
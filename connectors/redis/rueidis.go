@@ -1,19 +1,111 @@
 package redis
 
 import (
+	"context"
 	"github.com/redis/rueidis"
-	"strconv"
+	"net"
+	"time"
 )
 
+// NewRueidisClient validates cfg and creates a rueidis client. In cluster mode
+// rueidis discovers the topology from the seed nodes in cfg.Addrs.
 func NewRueidisClient(cfg Config) (rueidis.Client, error) {
-	client, err := rueidis.NewClient(rueidis.ClientOption{
-		InitAddress: []string{cfg.Host + ":" + strconv.Itoa(cfg.Port)},
-		Username:    "",
-		Password:    cfg.Password,
-		SelectDB:    cfg.Db,
-	})
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Timeout: cfg.DialTimeout}
+	opts := rueidis.ClientOption{
+		InitAddress:      cfg.addrs(),
+		Username:         cfg.Username,
+		Password:         cfg.Password,
+		SelectDB:         cfg.Db,
+		BlockingPoolSize: cfg.PoolSize,
+		// rueidis has a single deadline for writing a command and reading its reply.
+		ConnWriteTimeout: max(cfg.ReadTimeout, cfg.WriteTimeout),
+		Dialer:           dialer,
+		TLSConfig:        tlsConfig,
+		DisableCache:     cfg.DisableClientCache,
+		// Without it rueidis probes for a cluster and may pick the cluster
+		// client, which rejects multi-key commands across slots.
+		ForceSingleClient: cfg.Mode == "" || cfg.Mode == ModeStandalone,
+	}
+	if cfg.Mode == ModeSentinel {
+		opts.Sentinel = rueidis.SentinelOption{
+			Dialer:    dialer,
+			TLSConfig: tlsConfig,
+			MasterSet: cfg.MasterName,
+			Username:  cfg.SentinelUsername,
+			Password:  cfg.SentinelPassword,
+		}
+	}
+	client, err := rueidis.NewClient(opts)
 	if err != nil {
 		return nil, err
 	}
 	return client, nil
 }
+
+type rueidisConnector struct {
+	client rueidis.Client
+}
+
+// NewRueidisConnector wraps an existing rueidis client.
+func NewRueidisConnector(client rueidis.Client) Connector {
+	return &rueidisConnector{client: client}
+}
+
+func (c *rueidisConnector) Ping(ctx context.Context) error {
+	return c.client.Do(ctx, c.client.B().Ping().Build()).Error()
+}
+
+func (c *rueidisConnector) Get(ctx context.Context, key string) (string, error) {
+	result, err := c.client.Do(ctx, c.client.B().Get().Key(key).Build()).ToString()
+	if rueidis.IsRedisNil(err) {
+		return "", ErrKeyNotFound
+	}
+	return result, err
+}
+
+func (c *rueidisConnector) Set(ctx context.Context, key string, value string, expiration time.Duration) error {
+	cmd := c.client.B().Set().Key(key).Value(value)
+	switch {
+	case expiration == KeepTTL:
+		return c.client.Do(ctx, cmd.Keepttl().Build()).Error()
+	case expiration > 0:
+		return c.client.Do(ctx, cmd.PxMilliseconds(ceilMilliseconds(expiration)).Build()).Error()
+	}
+	return c.client.Do(ctx, cmd.Build()).Error()
+}
+
+func (c *rueidisConnector) Del(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.client.Do(ctx, c.client.B().Del().Key(keys...).Build()).Error()
+}
+
+func (c *rueidisConnector) Eval(ctx context.Context, script *Script, keys []string, args []string) (any, error) {
+	result, err := script.rueidis.Exec(ctx, c.client, keys, args).ToAny()
+	if rueidis.IsRedisNil(err) {
+		return nil, nil
+	}
+	return result, err
+}
+
+func (c *rueidisConnector) Do(ctx context.Context, name string, keys []string, args []string) (any, error) {
+	cmd := c.client.B().Arbitrary(name).Keys(keys...).Args(args...).ReadOnly()
+	result, err := c.client.Do(ctx, cmd).ToAny()
+	if rueidis.IsRedisNil(err) {
+		return nil, nil
+	}
+	return result, err
+}
+
+func (c *rueidisConnector) Close() error {
+	c.client.Close()
+	return nil
+}
@@ -0,0 +1,659 @@
+package redis
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/encryption"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SessionConfig struct {
+	// CookieName defaults to "session".
+	CookieName   string `json:"cookie_name"`
+	CookieDomain string `json:"cookie_domain"`
+	// CookiePath defaults to "/".
+	CookiePath string `json:"cookie_path"`
+	// SameSite is "lax" (default), "strict" or "none".
+	SameSite string `json:"same_site"`
+	// AllowInsecure drops the Secure attribute of the cookie, for local
+	// development over plain HTTP.
+	AllowInsecure bool `json:"allow_insecure"`
+	// IdleTimeout is the sliding expiration of a session, extended on every
+	// request. Defaults to 24h.
+	IdleTimeout time.Duration `json:"idle_timeout"`
+	// AbsoluteTimeout caps the lifetime of a session regardless of activity.
+	// Zero disables it.
+	AbsoluteTimeout time.Duration `json:"absolute_timeout"`
+	// SigningKeys sign the session ID in the cookie with HMAC-SHA256. The first
+	// key signs, all keys verify, so keys can be rotated.
+	SigningKeys []string `json:"signing_keys"`
+	// EncryptionKey is a base64 AES key encrypting the session ID in the cookie
+	// instead of signing it.
+	EncryptionKey string `json:"encryption_key"`
+	// KeyPrefix of the session keys in Redis, "session" by default.
+	KeyPrefix string `json:"key_prefix"`
+}
+
+func (cfg SessionConfig) withDefaults() SessionConfig {
+	if cfg.CookieName == "" {
+		cfg.CookieName = "session"
+	}
+	if cfg.CookiePath == "" {
+		cfg.CookiePath = "/"
+	}
+	if cfg.SameSite == "" {
+		cfg.SameSite = "lax"
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 24 * time.Hour
+	}
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = "session"
+	}
+	return cfg
+}
+
+// Session is the server-side state of a client, loaded by the SessionStore
+// middleware and reachable through SessionFromContext.
+type Session struct {
+	mutex     sync.RWMutex
+	id        string
+	userId    string
+	createdAt time.Time
+	lastSeen  time.Time
+	values    map[string]json.RawMessage
+	dirty     bool
+	isNew     bool
+	destroyed bool
+	// onFirstWrite sends the cookie of a new session once it has a value.
+	onFirstWrite func(*Session)
+}
+
+// sessionData is the stored representation of a Session.
+type sessionData struct {
+	UserId    string                     `json:"userId,omitempty"`
+	CreatedAt time.Time                  `json:"createdAt"`
+	LastSeen  time.Time                  `json:"lastSeen"`
+	Values    map[string]json.RawMessage `json:"values,omitempty"`
+}
+
+func (s *Session) ID() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.id
+}
+
+// UserId returns the user the session was logged in as, "" if anonymous.
+func (s *Session) UserId() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.userId
+}
+
+// Set stores value JSON encoded under key. The session is saved at the end of
+// the request.
+func (s *Session) Set(key string, value interface{}) error {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to encode session value %s", key))
+	}
+	s.mutex.Lock()
+	first := s.isNew && !s.dirty
+	s.values[key] = bytes
+	s.dirty = true
+	s.mutex.Unlock()
+	if first && s.onFirstWrite != nil {
+		s.onFirstWrite(s)
+	}
+	return nil
+}
+
+// Get decodes the value stored under key into value and reports whether it
+// was found.
+func (s *Session) Get(key string, value interface{}) (bool, error) {
+	s.mutex.RLock()
+	bytes, ok := s.values[key]
+	s.mutex.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(bytes, value)
+}
+
+func (s *Session) Delete(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.dirty = true
+	}
+}
+
+// SessionFromContext returns the session loaded by the SessionStore
+// middleware, or nil outside of it.
+func SessionFromContext(ctx *context.Context) *Session {
+	if session, ok := ctx.Get(enums.Session).(*Session); ok {
+		return session
+	}
+	if ctx.GinContext != nil {
+		if session, ok := ctx.GinContext.Value(enums.Session).(*Session); ok {
+			return session
+		}
+	}
+	return nil
+}
+
+// Sessions and the session sets of their users hash to different cluster
+// slots, so every script below touches a single key and the store updates
+// them one after the other. Writes go through the scripts, reads through
+// Connector.Do.
+
+// saveSession stores the session ARGV[1] under KEYS[1] for ARGV[2]
+// milliseconds. Unless ARGV[3] is "true", it only replaces a stored session,
+// so a request saving a session revoked while it ran cannot bring it back.
+// Returns 1 if the session was stored.
+var saveSession = NewScript(`
+if ARGV[3] == "true" then
+  redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+  return 1
+end
+if redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2], "XX") then
+  return 1
+end
+return 0
+`)
+
+// indexSession adds the session ARGV[1] to the session set KEYS[1] of its
+// user, which expires with the longest-lived session of the user.
+var indexSession = NewScript(`
+redis.call("SADD", KEYS[1], ARGV[1])
+if redis.call("PTTL", KEYS[1]) < tonumber(ARGV[2]) then
+  redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// unindexSessions removes the sessions ARGV from the session set KEYS[1].
+var unindexSessions = NewScript(`
+return redis.call("SREM", KEYS[1], unpack(ARGV))
+`)
+
+// deleteKey deletes the session or session set KEYS[1] and returns 1 if it
+// existed.
+var deleteKey = NewScript(`
+return redis.call("DEL", KEYS[1])
+`)
+
+// pruneSample is the number of members of a session set checked for expired
+// sessions on every login.
+const pruneSample = 10
+
+// SessionStore keeps sessions in Redis and their IDs in signed or encrypted
+// cookies.
+type SessionStore struct {
+	cache         *Cache
+	cfg           SessionConfig
+	signingKeys   [][]byte
+	encryptionKey []byte
+	logger        *zap.Logger
+}
+
+func NewSessionStore(cache *Cache, cfg SessionConfig, logger *zap.Logger) (*SessionStore, error) {
+	cfg = cfg.withDefaults()
+	store := &SessionStore{
+		cache:  cache,
+		cfg:    cfg,
+		logger: logger,
+	}
+	if store.logger == nil {
+		store.logger = cache.logger
+	}
+	switch cfg.SameSite {
+	case "lax", "strict", "none":
+	default:
+		return nil, errors.Wrap(ErrInvalidConfig, fmt.Sprintf("unknown same_site %q", cfg.SameSite))
+	}
+	if cfg.EncryptionKey != "" {
+		key, err := encryption.ParseAESKey(cfg.EncryptionKey)
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidConfig, "invalid session encryption_key")
+		}
+		store.encryptionKey = key
+	}
+	for _, key := range cfg.SigningKeys {
+		if len(key) < 32 {
+			return nil, errors.Wrap(ErrInvalidConfig, "session signing keys must be at least 32 bytes long")
+		}
+		store.signingKeys = append(store.signingKeys, []byte(key))
+	}
+	if store.encryptionKey == nil && len(store.signingKeys) == 0 {
+		return nil, errors.Wrap(ErrInvalidConfig, "sessions need signing_keys or an encryption_key")
+	}
+	return store, nil
+}
+
+// Middleware loads the session of the request, or starts an anonymous one,
+// and saves it once the handlers are done. Anonymous sessions are only stored
+// once a value is set.
+func (store *SessionStore) Middleware() gin.HandlerFunc {
+	return func(ginCtx *gin.Context) {
+		// Not cancelled with the request, so the session is saved even if the
+		// client went away.
+		ctx := context.NewContextFromGinContext(ginCtx)
+
+		session := store.load(ctx)
+		ginCtx.Set(enums.Session, session)
+		if session.isNew {
+			session.onFirstWrite = func(session *Session) {
+				store.setCookie(ginCtx, session)
+			}
+		} else {
+			// Sliding expiration: the cookie lives as long as the stored session.
+			store.setCookie(ginCtx, session)
+		}
+
+		ginCtx.Next()
+
+		session.mutex.RLock()
+		save := !session.destroyed && (session.dirty || (!session.isNew && store.needsRefresh(session)))
+		// Only a new session is created, any other was loaded from Redis and
+		// is not brought back if it was revoked in the meantime.
+		created := session.isNew
+		session.mutex.RUnlock()
+		if save {
+			if err := store.save(ctx, session, created); err != nil {
+				store.logger.Error("Failed to save session",
+					zap.Any(enums.RequestId, requestId(ctx)),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+// Login regenerates the ID of the current session, keeping its values, and
+// binds it to userId. Regenerating on login prevents session fixation.
+func (store *SessionStore) Login(ctx *context.Context, userId string) error {
+	session, err := store.current(ctx)
+	if err != nil {
+		return err
+	}
+	session.mutex.Lock()
+	oldId, oldUserId, wasNew := session.id, session.userId, session.isNew
+	session.id = newSessionId()
+	session.userId = userId
+	session.createdAt = time.Now()
+	session.isNew = false
+	session.dirty = true
+	session.mutex.Unlock()
+
+	if !wasNew {
+		if err := store.destroy(ctx, oldId, oldUserId); err != nil {
+			return err
+		}
+	}
+	if err := store.save(ctx, session, true); err != nil {
+		return err
+	}
+	store.setCookie(ctx.GinContext, session)
+	if err := store.prune(ctx, userId); err != nil {
+		store.logger.Warn("Failed to prune expired sessions",
+			zap.Any(enums.RequestId, requestId(ctx)),
+			zap.Error(err),
+		)
+	}
+	return nil
+}
+
+// Logout destroys the current session and clears its cookie.
+func (store *SessionStore) Logout(ctx *context.Context) error {
+	session, err := store.current(ctx)
+	if err != nil {
+		return err
+	}
+	session.mutex.Lock()
+	session.destroyed = true
+	id, userId := session.id, session.userId
+	session.mutex.Unlock()
+
+	store.clearCookie(ctx.GinContext)
+	return store.destroy(ctx, id, userId)
+}
+
+// LogoutEverywhere destroys every session of userId and returns how many were
+// destroyed. A session logged in while it runs may survive.
+func (store *SessionStore) LogoutEverywhere(ctx *context.Context, userId string) (int64, error) {
+	userKey := store.userKey(userId)
+	ids, err := store.sessionIds(ctx, userKey, 0)
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("failed to list sessions of user %s", userId))
+	}
+	var deleted int64
+	for _, id := range ids {
+		existed, err := store.deleteKey(ctx, store.sessionKey(id))
+		if err != nil {
+			return deleted, errors.Wrap(err, fmt.Sprintf("failed to destroy sessions of user %s", userId))
+		}
+		if existed {
+			deleted++
+		}
+	}
+	if _, err := store.deleteKey(ctx, userKey); err != nil {
+		return deleted, errors.Wrap(err, fmt.Sprintf("failed to destroy sessions of user %s", userId))
+	}
+	if session := SessionFromContext(ctx); session != nil && session.UserId() == userId {
+		session.mutex.Lock()
+		session.destroyed = true
+		session.mutex.Unlock()
+		if ctx.GinContext != nil {
+			store.clearCookie(ctx.GinContext)
+		}
+	}
+	return deleted, nil
+}
+
+func (store *SessionStore) current(ctx *context.Context) (*Session, error) {
+	session := SessionFromContext(ctx)
+	if session == nil || ctx.GinContext == nil {
+		return nil, ErrNoSession
+	}
+	return session, nil
+}
+
+// load returns the session named by the cookie of the request, or a new
+// anonymous session if there is none or it expired.
+func (store *SessionStore) load(ctx *context.Context) *Session {
+	if cookie, err := ctx.GinContext.Cookie(store.cfg.CookieName); err == nil {
+		if id, ok := store.decodeCookie(cookie); ok {
+			session, err := store.get(ctx, id)
+			switch {
+			case err == nil:
+				return session
+			case !errors.Is(err, ErrKeyNotFound):
+				store.logger.Error("Failed to load session",
+					zap.Any(enums.RequestId, requestId(ctx)),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+	now := time.Now()
+	return &Session{
+		id:        newSessionId(),
+		createdAt: now,
+		lastSeen:  now,
+		values:    map[string]json.RawMessage{},
+		isNew:     true,
+	}
+}
+
+func (store *SessionStore) get(ctx *context.Context, id string) (*Session, error) {
+	result, err := store.cache.conn.Get(ctx.Context, store.sessionKey(id))
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := store.cache.decode(store.sessionKey(id), []byte(result))
+	if err != nil {
+		return nil, err
+	}
+	var data sessionData
+	if err := json.Unmarshal(decoded, &data); err != nil {
+		return nil, errors.Wrap(err, "failed to decode session")
+	}
+	if store.cfg.AbsoluteTimeout > 0 && time.Since(data.CreatedAt) > store.cfg.AbsoluteTimeout {
+		return nil, ErrKeyNotFound
+	}
+	if data.Values == nil {
+		data.Values = map[string]json.RawMessage{}
+	}
+	return &Session{
+		id:        id,
+		userId:    data.UserId,
+		createdAt: data.CreatedAt,
+		lastSeen:  data.LastSeen,
+		values:    data.Values,
+	}, nil
+}
+
+// save stores session, creating it if create is set and otherwise only if it
+// is still stored. A session revoked since it was loaded is marked destroyed.
+func (store *SessionStore) save(ctx *context.Context, session *Session, create bool) error {
+	session.mutex.Lock()
+	session.lastSeen = time.Now()
+	data := sessionData{
+		UserId:    session.userId,
+		CreatedAt: session.createdAt,
+		LastSeen:  session.lastSeen,
+		Values:    session.values,
+	}
+	id, ttl := session.id, store.ttl(session)
+	bytes, err := json.Marshal(data)
+	session.dirty = false
+	session.isNew = false
+	session.mutex.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "failed to encode session")
+	}
+	if bytes, err = store.cache.encode(store.sessionKey(id), bytes); err != nil {
+		return err
+	}
+
+	result, err := store.cache.conn.Eval(ctx.Context, saveSession, []string{store.sessionKey(id)}, []string{
+		string(bytes),
+		milliseconds(ttl),
+		strconv.FormatBool(create),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to save session")
+	}
+	if saved, err := int64Reply(result); err != nil || saved == 0 {
+		session.mutex.Lock()
+		session.destroyed = true
+		session.mutex.Unlock()
+		return err
+	}
+	if data.UserId == "" {
+		return nil
+	}
+	_, err = store.cache.conn.Eval(ctx.Context, indexSession,
+		[]string{store.userKey(data.UserId)},
+		[]string{id, milliseconds(ttl)},
+	)
+	return errors.Wrap(err, "failed to index session")
+}
+
+func (store *SessionStore) destroy(ctx *context.Context, id string, userId string) error {
+	if _, err := store.deleteKey(ctx, store.sessionKey(id)); err != nil {
+		return errors.Wrap(err, "failed to destroy session")
+	}
+	if userId == "" {
+		return nil
+	}
+	_, err := store.cache.conn.Eval(ctx.Context, unindexSessions, []string{store.userKey(userId)}, []string{id})
+	return errors.Wrap(err, "failed to unindex session")
+}
+
+// prune removes a few expired sessions from the session set of userId, which
+// would otherwise keep growing while the user logs in regularly.
+func (store *SessionStore) prune(ctx *context.Context, userId string) error {
+	userKey := store.userKey(userId)
+	ids, err := store.sessionIds(ctx, userKey, pruneSample)
+	if err != nil {
+		return err
+	}
+	var expired []string
+	for _, id := range ids {
+		result, err := store.cache.conn.Do(ctx.Context, "EXISTS", []string{store.sessionKey(id)}, nil)
+		if err != nil {
+			return err
+		}
+		if exists, err := int64Reply(result); err != nil {
+			return err
+		} else if exists == 0 {
+			expired = append(expired, id)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	_, err = store.cache.conn.Eval(ctx.Context, unindexSessions, []string{userKey}, expired)
+	return err
+}
+
+// sessionIds returns count random members of the session set userKey, or all
+// of them if count is 0.
+func (store *SessionStore) sessionIds(ctx *context.Context, userKey string, count int) ([]string, error) {
+	var result any
+	var err error
+	if count > 0 {
+		result, err = store.cache.conn.Do(ctx.Context, "SRANDMEMBER", []string{userKey}, []string{strconv.Itoa(count)})
+	} else {
+		result, err = store.cache.conn.Do(ctx.Context, "SMEMBERS", []string{userKey}, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	members, _ := result.([]any)
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		if id, ok := member.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// deleteKey deletes key and reports whether it existed.
+func (store *SessionStore) deleteKey(ctx *context.Context, key string) (bool, error) {
+	result, err := store.cache.conn.Eval(ctx.Context, deleteKey, []string{key}, nil)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := int64Reply(result)
+	return deleted == 1, err
+}
+
+// ttl returns how long the session lives from now on.
+func (store *SessionStore) ttl(session *Session) time.Duration {
+	ttl := store.cfg.IdleTimeout
+	if store.cfg.AbsoluteTimeout > 0 {
+		remaining := time.Until(session.createdAt.Add(store.cfg.AbsoluteTimeout))
+		ttl = max(min(ttl, remaining), time.Millisecond)
+	}
+	return ttl
+}
+
+// needsRefresh limits the writes of sliding expiration to one per minute or
+// per tenth of the idle timeout, whichever is shorter.
+func (store *SessionStore) needsRefresh(session *Session) bool {
+	return time.Since(session.lastSeen) >= min(time.Minute, store.cfg.IdleTimeout/10)
+}
+
+func (store *SessionStore) sessionKey(id string) string {
+	return store.cfg.KeyPrefix + keySeparator + id
+}
+
+// userKey names the set indexing the sessions of userId. Session IDs never
+// contain the separator, so it cannot clash with a session key.
+func (store *SessionStore) userKey(userId string) string {
+	return store.cfg.KeyPrefix + keySeparator + "user" + keySeparator + partEscaper.Replace(userId)
+}
+
+func (store *SessionStore) setCookie(ginCtx *gin.Context, session *Session) {
+	session.mutex.RLock()
+	value := store.encodeCookie(session.id)
+	ttl := store.ttl(session)
+	session.mutex.RUnlock()
+	http.SetCookie(ginCtx.Writer, store.cookie(value, int(ttl.Seconds())))
+}
+
+func (store *SessionStore) clearCookie(ginCtx *gin.Context) {
+	http.SetCookie(ginCtx.Writer, store.cookie("", -1))
+}
+
+func (store *SessionStore) cookie(value string, maxAge int) *http.Cookie {
+	sameSite := http.SameSiteLaxMode
+	switch store.cfg.SameSite {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+	return &http.Cookie{
+		Name:     store.cfg.CookieName,
+		Value:    value,
+		Path:     store.cfg.CookiePath,
+		Domain:   store.cfg.CookieDomain,
+		MaxAge:   maxAge,
+		Secure:   !store.cfg.AllowInsecure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	}
+}
+
+// encodeCookie encrypts id with the encryption key if there is one and signs
+// it with the first signing key otherwise.
+func (store *SessionStore) encodeCookie(id string) string {
+	if store.encryptionKey != nil {
+		ciphertext, err := encryption.AESGCMEncrypt(store.encryptionKey, []byte(id), []byte(store.cfg.CookieName))
+		if err != nil {
+			// Only fails if the system random source does.
+			panic(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(ciphertext)
+	}
+	return id + "." + base64.RawURLEncoding.EncodeToString(sign(store.signingKeys[0], id))
+}
+
+func (store *SessionStore) decodeCookie(value string) (string, bool) {
+	if store.encryptionKey != nil {
+		ciphertext, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return "", false
+		}
+		id, err := encryption.AESGCMDecrypt(store.encryptionKey, ciphertext, []byte(store.cfg.CookieName))
+		return string(id), err == nil
+	}
+	id, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return "", false
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return "", false
+	}
+	for _, key := range store.signingKeys {
+		if hmac.Equal(decoded, sign(key, id)) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func sign(key []byte, id string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id))
+	return mac.Sum(nil)
+}
+
+func newSessionId() string {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		// Only fails if the system random source does.
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(id)
+}
@@ -0,0 +1,63 @@
+package redis_test
+
+import (
+	"github.com/NitinD97/common-utils/connectors/redis"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSessionRevokedDuringRequestIsNotSavedBack(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for driver, newCache := range drivers() {
+		t.Run(driver, func(t *testing.T) {
+			cache, server := newCache(t)
+			store, err := redis.NewSessionStore(cache, redis.SessionConfig{
+				SigningKeys:   []string{"0123456789abcdef0123456789abcdef"},
+				AllowInsecure: true,
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sessionId string
+			router := gin.New()
+			router.Use(store.Middleware())
+			router.POST("/login", func(ginCtx *gin.Context) {
+				ctx := context.NewContextFromGinContext(ginCtx)
+				if err := store.Login(ctx, "user-1"); err != nil {
+					t.Fatal(err)
+				}
+				sessionId = redis.SessionFromContext(ctx).ID()
+			})
+			router.POST("/cart", func(ginCtx *gin.Context) {
+				session := redis.SessionFromContext(context.NewContextFromGinContext(ginCtx))
+				_ = session.Set("cart", []string{"book"})
+				// Another request logs the user out everywhere meanwhile.
+				if _, err := store.LogoutEverywhere(context.NewContext(), "user-1"); err != nil {
+					t.Fatal(err)
+				}
+			})
+
+			login := httptest.NewRecorder()
+			router.ServeHTTP(login, httptest.NewRequest(http.MethodPost, "/login", nil))
+			if !server.Exists("session:" + sessionId) {
+				t.Fatal("expected the session to be stored")
+			}
+
+			request := httptest.NewRequest(http.MethodPost, "/cart", nil)
+			for _, cookie := range login.Result().Cookies() {
+				request.AddCookie(cookie)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), request)
+
+			if server.Exists("session:" + sessionId) {
+				t.Error("revoked session was saved back")
+			}
+			if server.Exists("session:user:user-1") {
+				t.Error("revoked session was indexed again")
+			}
+		})
+	}
+}
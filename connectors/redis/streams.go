@@ -0,0 +1,400 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/goccy/go-json"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	streamFieldPayload    = "payload"
+	streamFieldRequestId  = "requestId"
+	streamFieldSourceId   = "sourceId"
+	streamFieldDeliveries = "deliveries"
+	deadLetterSuffix      = ":dead"
+)
+
+// StreamMessage is a decoded stream entry handed to a StreamHandler.
+type StreamMessage[T any] struct {
+	ID         string
+	Stream     string
+	RequestId  string
+	Deliveries int64
+	Payload    T
+}
+
+// StreamHandler processes a single stream entry. Returning an error leaves the
+// entry pending so it is retried once it has been idle for ClaimMinIdle.
+type StreamHandler[T any] func(ctx *context.Context, msg *StreamMessage[T]) error
+
+// StreamProducer appends typed entries to a stream, trimming it to roughly
+// maxLen entries.
+type StreamProducer[T any] struct {
+	cache  *Cache
+	stream string
+	maxLen int64
+}
+
+func NewStreamProducer[T any](cache *Cache, stream string, maxLen int64) *StreamProducer[T] {
+	return &StreamProducer[T]{
+		cache:  cache,
+		stream: stream,
+		maxLen: maxLen,
+	}
+}
+
+// Publish appends payload to the stream and returns the ID of the new entry.
+// The request ID carried by ctx travels with the entry.
+func (p *StreamProducer[T]) Publish(ctx *context.Context, payload T) (string, error) {
+	if err := p.cache.requireGoRedis(); err != nil {
+		return "", err
+	}
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode stream payload")
+	}
+	values := map[string]interface{}{streamFieldPayload: bytes}
+	if id := requestId(ctx); id != "" {
+		values[streamFieldRequestId] = id
+	}
+	id, err := p.cache.rDB.XAdd(ctx.Context, &redis.XAddArgs{
+		Stream: p.stream,
+		MaxLen: p.maxLen,
+		Approx: true,
+		Values: values,
+	}).Result()
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("failed to publish to stream %s", p.stream))
+	}
+	return id, nil
+}
+
+type StreamConsumerConfig struct {
+	Stream   string `json:"stream"`
+	Group    string `json:"group"`
+	Consumer string `json:"consumer"`
+	// Concurrency is the number of workers handling entries in parallel.
+	Concurrency int `json:"concurrency"`
+	// BatchSize is the maximum number of entries fetched per XREADGROUP or
+	// XAUTOCLAIM call.
+	BatchSize int64 `json:"batch_size"`
+	// Block is how long XREADGROUP waits for new entries.
+	Block time.Duration `json:"block"`
+	// MaxDeliveries is how many times an entry is delivered before it is moved
+	// to the dead-letter stream.
+	MaxDeliveries int64 `json:"max_deliveries"`
+	// ClaimMinIdle is how long an entry stays pending before it is reclaimed
+	// and retried. It must exceed the longest expected handler run.
+	ClaimMinIdle time.Duration `json:"claim_min_idle"`
+	// ClaimInterval is how often pending entries are scanned for reclaiming.
+	ClaimInterval time.Duration `json:"claim_interval"`
+	// DeadLetterStream defaults to Stream + ":dead".
+	DeadLetterStream string `json:"dead_letter_stream"`
+}
+
+func (cfg StreamConsumerConfig) withDefaults() StreamConsumerConfig {
+	if cfg.Consumer == "" {
+		hostname, _ := os.Hostname()
+		cfg.Consumer = hostname + "-" + strconv.Itoa(os.Getpid())
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 10
+	}
+	if cfg.Block <= 0 {
+		cfg.Block = 5 * time.Second
+	}
+	if cfg.MaxDeliveries <= 0 {
+		cfg.MaxDeliveries = 5
+	}
+	if cfg.ClaimMinIdle <= 0 {
+		cfg.ClaimMinIdle = time.Minute
+	}
+	if cfg.ClaimInterval <= 0 {
+		cfg.ClaimInterval = 30 * time.Second
+	}
+	if cfg.DeadLetterStream == "" {
+		cfg.DeadLetterStream = cfg.Stream + deadLetterSuffix
+	}
+	return cfg
+}
+
+// StreamConsumer reads a stream as a member of a consumer group and hands
+// entries to a pool of workers.
+type StreamConsumer[T any] struct {
+	cache   *Cache
+	cfg     StreamConsumerConfig
+	handler StreamHandler[T]
+	logger  *zap.Logger
+}
+
+type streamDelivery struct {
+	msg        redis.XMessage
+	deliveries int64
+}
+
+func NewStreamConsumer[T any](cache *Cache, cfg StreamConsumerConfig, handler StreamHandler[T], logger *zap.Logger) *StreamConsumer[T] {
+	if logger == nil {
+		logger = cache.logger
+	}
+	return &StreamConsumer[T]{
+		cache:   cache,
+		cfg:     cfg.withDefaults(),
+		handler: handler,
+		logger:  logger,
+	}
+}
+
+// Run creates the consumer group if needed and consumes the stream until ctx
+// is cancelled. On cancellation it stops fetching, waits for in-flight entries
+// to finish and returns nil.
+func (c *StreamConsumer[T]) Run(ctx *context.Context) error {
+	if err := c.cache.requireGoRedis(); err != nil {
+		return err
+	}
+	err := c.cache.rDB.XGroupCreateMkStream(ctx.Context, c.cfg.Stream, c.cfg.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return errors.Wrap(err, fmt.Sprintf("failed to create consumer group %s", c.cfg.Group))
+	}
+
+	deliveries := make(chan streamDelivery)
+	var wg sync.WaitGroup
+	for i := 0; i < c.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range deliveries {
+				c.process(ctx, d)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(deliveries)
+
+	claimTicker := time.NewTicker(c.cfg.ClaimInterval)
+	defer claimTicker.Stop()
+	c.claim(ctx, deliveries)
+
+	for {
+		select {
+		case <-ctx.Context.Done():
+			return nil
+		case <-claimTicker.C:
+			c.claim(ctx, deliveries)
+		default:
+		}
+
+		streams, err := c.cache.rDB.XReadGroup(ctx.Context, &redis.XReadGroupArgs{
+			Group:    c.cfg.Group,
+			Consumer: c.cfg.Consumer,
+			Streams:  []string{c.cfg.Stream, ">"},
+			Count:    c.cfg.BatchSize,
+			Block:    c.cfg.Block,
+		}).Result()
+		switch {
+		case errors.Is(err, redis.Nil), ctx.Context.Err() != nil:
+			continue
+		case err != nil:
+			c.logger.Error("Failed to read stream",
+				zap.String("stream", c.cfg.Stream),
+				zap.String("group", c.cfg.Group),
+				zap.Error(err),
+			)
+			sleepContext(ctx, time.Second)
+			continue
+		}
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				if !c.dispatch(ctx, deliveries, streamDelivery{msg: msg, deliveries: 1}) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// claim takes over entries that have been pending for longer than
+// ClaimMinIdle. Entries delivered more than MaxDeliveries times are moved to
+// the dead-letter stream, the rest are retried.
+func (c *StreamConsumer[T]) claim(ctx *context.Context, deliveries chan<- streamDelivery) {
+	start := "0-0"
+	for {
+		msgs, next, err := c.cache.rDB.XAutoClaim(ctx.Context, &redis.XAutoClaimArgs{
+			Stream:   c.cfg.Stream,
+			Group:    c.cfg.Group,
+			MinIdle:  c.cfg.ClaimMinIdle,
+			Start:    start,
+			Count:    c.cfg.BatchSize,
+			Consumer: c.cfg.Consumer,
+		}).Result()
+		if err != nil {
+			if ctx.Context.Err() == nil {
+				c.logger.Error("Failed to claim pending stream entries",
+					zap.String("stream", c.cfg.Stream),
+					zap.String("group", c.cfg.Group),
+					zap.Error(err),
+				)
+			}
+			return
+		}
+
+		counts := c.deliveryCounts(ctx, msgs)
+		for _, msg := range msgs {
+			count := counts[msg.ID]
+			if count > c.cfg.MaxDeliveries {
+				c.deadLetter(ctx, msg, count)
+				continue
+			}
+			if !c.dispatch(ctx, deliveries, streamDelivery{msg: msg, deliveries: count}) {
+				return
+			}
+		}
+
+		if next == "0-0" || len(msgs) == 0 {
+			return
+		}
+		start = next
+	}
+}
+
+// deliveryCounts looks up how many times each claimed entry has been
+// delivered. Each entry is queried on its own, since a range would also
+// return the other entries pending for the consumer in between.
+func (c *StreamConsumer[T]) deliveryCounts(ctx *context.Context, msgs []redis.XMessage) map[string]int64 {
+	counts := make(map[string]int64, len(msgs))
+	if len(msgs) == 0 {
+		return counts
+	}
+	cmds := make([]*redis.XPendingExtCmd, len(msgs))
+	_, err := c.cache.rDB.Pipelined(ctx.Context, func(pipe redis.Pipeliner) error {
+		for i, msg := range msgs {
+			cmds[i] = pipe.XPendingExt(ctx.Context, &redis.XPendingExtArgs{
+				Stream: c.cfg.Stream,
+				Group:  c.cfg.Group,
+				Start:  msg.ID,
+				End:    msg.ID,
+				Count:  1,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		c.logger.Warn("Failed to read stream delivery counts",
+			zap.String("stream", c.cfg.Stream),
+			zap.Error(err),
+		)
+	}
+	for _, cmd := range cmds {
+		for _, p := range cmd.Val() {
+			counts[p.ID] = p.RetryCount
+		}
+	}
+	return counts
+}
+
+func (c *StreamConsumer[T]) dispatch(ctx *context.Context, deliveries chan<- streamDelivery, d streamDelivery) bool {
+	select {
+	case deliveries <- d:
+		return true
+	case <-ctx.Context.Done():
+		return false
+	}
+}
+
+func (c *StreamConsumer[T]) process(ctx *context.Context, d streamDelivery) {
+	// Let the handler and the ack finish even if ctx is cancelled meanwhile.
+	msgCtx := ctx.WithoutCancel()
+	msg, err := decodeStreamMessage[T](c.cfg.Stream, d)
+	if err != nil {
+		c.logger.Error("Failed to decode stream entry",
+			zap.String("stream", c.cfg.Stream),
+			zap.String("id", d.msg.ID),
+			zap.Error(err),
+		)
+		c.deadLetter(msgCtx, d.msg, d.deliveries)
+		return
+	}
+	if msg.RequestId != "" {
+		msgCtx.Set(enums.RequestId, msg.RequestId)
+	}
+
+	if err := c.handler(msgCtx, msg); err != nil {
+		c.logger.Warn("Stream handler failed",
+			zap.String("stream", c.cfg.Stream),
+			zap.String("id", msg.ID),
+			zap.Int64("deliveries", msg.Deliveries),
+			zap.Any(enums.RequestId, msg.RequestId),
+			zap.Error(err),
+		)
+		return
+	}
+	if err := c.cache.rDB.XAck(msgCtx.Context, c.cfg.Stream, c.cfg.Group, msg.ID).Err(); err != nil {
+		c.logger.Error("Failed to ack stream entry",
+			zap.String("stream", c.cfg.Stream),
+			zap.String("id", msg.ID),
+			zap.Error(err),
+		)
+	}
+}
+
+// deadLetter copies the entry to the dead-letter stream, then acks the
+// original. The two streams may live on different cluster slots, so they are
+// not updated in a transaction: an entry whose ack fails stays pending and is
+// dead-lettered again by the next claim.
+func (c *StreamConsumer[T]) deadLetter(ctx *context.Context, msg redis.XMessage, deliveries int64) {
+	values := make(map[string]interface{}, len(msg.Values)+2)
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	values[streamFieldSourceId] = msg.ID
+	values[streamFieldDeliveries] = deliveries
+
+	err := c.cache.rDB.XAdd(ctx.Context, &redis.XAddArgs{Stream: c.cfg.DeadLetterStream, Values: values}).Err()
+	if err == nil {
+		err = c.cache.rDB.XAck(ctx.Context, c.cfg.Stream, c.cfg.Group, msg.ID).Err()
+	}
+	if err != nil {
+		c.logger.Error("Failed to dead-letter stream entry",
+			zap.String("stream", c.cfg.Stream),
+			zap.String("id", msg.ID),
+			zap.Error(err),
+		)
+		return
+	}
+	c.logger.Warn("Moved stream entry to dead-letter stream",
+		zap.String("stream", c.cfg.Stream),
+		zap.String("deadLetterStream", c.cfg.DeadLetterStream),
+		zap.String("id", msg.ID),
+		zap.Int64("deliveries", deliveries),
+	)
+}
+
+func decodeStreamMessage[T any](stream string, d streamDelivery) (*StreamMessage[T], error) {
+	msg := &StreamMessage[T]{
+		ID:         d.msg.ID,
+		Stream:     stream,
+		Deliveries: d.deliveries,
+	}
+	if id, ok := d.msg.Values[streamFieldRequestId].(string); ok {
+		msg.RequestId = id
+	}
+	payload, ok := d.msg.Values[streamFieldPayload].(string)
+	if !ok {
+		return nil, errors.New("stream entry has no payload")
+	}
+	if err := json.Unmarshal([]byte(payload), &msg.Payload); err != nil {
+		return nil, errors.Wrap(err, "failed to decode stream payload")
+	}
+	return msg, nil
+}
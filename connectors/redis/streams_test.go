@@ -0,0 +1,177 @@
+package redis_test
+
+import (
+	stdcontext "context"
+	"github.com/NitinD97/common-utils/connectors/redis"
+	"github.com/NitinD97/common-utils/connectors/redis/redistest"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/NitinD97/common-utils/errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type order struct {
+	Id int `json:"id"`
+}
+
+// runUntilCleanup runs run in the background with a context cancelled, and
+// waited for, when t finishes.
+func runUntilCleanup(t *testing.T, run func(ctx *context.Context) error) {
+	ctx := context.NewContext()
+	var cancel stdcontext.CancelFunc
+	ctx.Context, cancel = stdcontext.WithCancel(ctx.Context)
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// eventually fails t unless condition holds within a second.
+func eventually(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatal(message)
+}
+
+// pending returns the number of entries of stream pending in group.
+func pending(t *testing.T, cache *redis.Cache, stream string, group string) int64 {
+	result, err := cache.Connector().Do(stdcontext.Background(), "XPENDING", []string{stream}, []string{group})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.([]any)[0].(int64)
+}
+
+func newStreamConsumerConfig() redis.StreamConsumerConfig {
+	return redis.StreamConsumerConfig{
+		Stream:        "orders",
+		Group:         "billing",
+		Consumer:      "worker-1",
+		Block:         10 * time.Millisecond,
+		ClaimMinIdle:  time.Minute,
+		ClaimInterval: 10 * time.Millisecond,
+	}
+}
+
+func TestStreamProducerTrims(t *testing.T) {
+	cache, server := redistest.NewCache(t)
+	producer := redis.NewStreamProducer[order](cache, "orders", 3)
+	for i := range 10 {
+		if _, err := producer.Publish(context.NewContext(), order{Id: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Redis trims approximately, in whole macro nodes; the stand-in trims
+	// exactly.
+	entries, _ := server.Stream("orders")
+	if len(entries) != 3 {
+		t.Fatalf("expected the stream to be trimmed to 3 entries, got %d", len(entries))
+	}
+}
+
+func TestStreamConsumerAcks(t *testing.T) {
+	cache, _ := redistest.NewCache(t)
+	ctx := context.NewContext()
+	ctx.Set(enums.RequestId, "request-1")
+	if _, err := redis.NewStreamProducer[order](cache, "orders", 100).Publish(ctx, order{Id: 42}); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan *redis.StreamMessage[order], 1)
+	consumer := redis.NewStreamConsumer(cache, newStreamConsumerConfig(), func(ctx *context.Context, msg *redis.StreamMessage[order]) error {
+		received <- msg
+		return nil
+	}, nil)
+	runUntilCleanup(t, consumer.Run)
+
+	select {
+	case msg := <-received:
+		if msg.Payload.Id != 42 || msg.RequestId != "request-1" || msg.Deliveries != 1 {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the entry was not consumed")
+	}
+	eventually(t, func() bool { return pending(t, cache, "orders", "billing") == 0 }, "the entry was not acked")
+}
+
+func TestStreamConsumerReclaimsFailedEntries(t *testing.T) {
+	cache, server := redistest.NewCache(t)
+	if _, err := redis.NewStreamProducer[order](cache, "orders", 100).Publish(context.NewContext(), order{Id: 42}); err != nil {
+		t.Fatal(err)
+	}
+
+	var deliveries atomic.Int64
+	consumer := redis.NewStreamConsumer(cache, newStreamConsumerConfig(), func(ctx *context.Context, msg *redis.StreamMessage[order]) error {
+		deliveries.Store(msg.Deliveries)
+		if msg.Deliveries == 1 {
+			return errors.New("payment service unavailable")
+		}
+		return nil
+	}, nil)
+	runUntilCleanup(t, consumer.Run)
+
+	eventually(t, func() bool { return deliveries.Load() == 1 }, "the entry was not consumed")
+	if pending(t, cache, "orders", "billing") != 1 {
+		t.Fatal("expected the failed entry to stay pending")
+	}
+	server.FastForward(2 * time.Minute)
+	eventually(t, func() bool { return deliveries.Load() == 2 }, "the entry was not reclaimed")
+	eventually(t, func() bool { return pending(t, cache, "orders", "billing") == 0 }, "the reclaimed entry was not acked")
+}
+
+func TestStreamConsumerDeadLetters(t *testing.T) {
+	cache, server := redistest.NewCache(t)
+	id, err := redis.NewStreamProducer[order](cache, "orders", 100).Publish(context.NewContext(), order{Id: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := newStreamConsumerConfig()
+	cfg.MaxDeliveries = 1
+	var deliveries atomic.Int64
+	consumer := redis.NewStreamConsumer(cache, cfg, func(ctx *context.Context, msg *redis.StreamMessage[order]) error {
+		deliveries.Add(1)
+		return errors.New("invalid order")
+	}, nil)
+	runUntilCleanup(t, consumer.Run)
+
+	eventually(t, func() bool { return deliveries.Load() == 1 }, "the entry was not consumed")
+	server.FastForward(2 * time.Minute)
+	eventually(t, func() bool {
+		entries, _ := server.Stream("orders:dead")
+		return len(entries) == 1
+	}, "the entry was not dead-lettered")
+
+	entries, _ := server.Stream("orders:dead")
+	if values := entries[0].Values; !containsPair(values, "sourceId", id) || !containsPair(values, "deliveries", "2") {
+		t.Errorf("unexpected dead-letter entry %v", values)
+	}
+	eventually(t, func() bool { return pending(t, cache, "orders", "billing") == 0 }, "the dead-lettered entry was not acked")
+	if deliveries.Load() != 1 {
+		t.Errorf("the dead-lettered entry was handled %d times", deliveries.Load())
+	}
+}
+
+// containsPair reports whether the field-value list values holds field set to
+// value.
+func containsPair(values []string, field string, value string) bool {
+	for i := 0; i+1 < len(values); i += 2 {
+		if values[i] == field && values[i+1] == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package redis
+
+import (
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/redis/go-redis/v9"
+	"strings"
+	"time"
+)
+
+const tagPrefix = "tag:"
+
+// KeepTTL as the expiration of Set and SetJson keeps the current TTL of the
+// key, like redis.KeepTTL in go-redis.
+const KeepTTL = redis.KeepTTL
+
+// setTagged sets KEYS[1] with the TTL ARGV[2] in milliseconds, 0 for none and
+// -1 to keep its current one, and registers it in the tag sets KEYS[2..]. A
+// tag set expires with its longest-lived member, and a few members whose keys
+// are gone are pruned on every write so persistent tag sets do not grow
+// forever.
+var setTagged = NewScript(`
+local key = KEYS[1]
+local ttl = tonumber(ARGV[2])
+if ttl == -1 then
+  redis.call("SET", key, ARGV[1], "KEEPTTL")
+  ttl = redis.call("PTTL", key)
+elseif ttl > 0 then
+  redis.call("SET", key, ARGV[1], "PX", ttl)
+else
+  redis.call("SET", key, ARGV[1])
+end
+for i = 2, #KEYS do
+  local tag = KEYS[i]
+  local existed = redis.call("EXISTS", tag) == 1
+  for _, member in ipairs(redis.call("SRANDMEMBER", tag, 10)) do
+    if redis.call("EXISTS", member) == 0 then
+      redis.call("SREM", tag, member)
+    end
+  end
+  redis.call("SADD", tag, key)
+  if ttl <= 0 then
+    redis.call("PERSIST", tag)
+  elseif not existed then
+    redis.call("PEXPIRE", tag, ttl)
+  else
+    local tag_ttl = redis.call("PTTL", tag)
+    if tag_ttl >= 0 and tag_ttl < ttl then
+      redis.call("PEXPIRE", tag, ttl)
+    end
+  end
+end
+return 1
+`)
+
+// invalidateTags deletes every member of the tag sets in KEYS and the sets
+// themselves.
+var invalidateTags = NewScript(`
+local deleted = 0
+for _, tag in ipairs(KEYS) do
+  local members = redis.call("SMEMBERS", tag)
+  for i = 1, #members, 500 do
+    deleted = deleted + redis.call("DEL", unpack(members, i, math.min(i + 499, #members)))
+  end
+  redis.call("DEL", tag)
+end
+return deleted
+`)
+
+func tagKeys(tags []string) []string {
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = tagPrefix + tag
+	}
+	return keys
+}
+
+func (cache *Cache) setWithTags(ctx *context.Context, key string, value string, expiration time.Duration, tags []string) error {
+	if len(tags) == 0 {
+		return cache.conn.Set(ctx.Context, key, value, expiration)
+	}
+	ttl := "-1"
+	if expiration != KeepTTL {
+		ttl = milliseconds(max(expiration, 0))
+	}
+	keys := append([]string{key}, tagKeys(tags)...)
+	_, err := cache.conn.Eval(ctx.Context, setTagged, keys, []string{value, ttl})
+	return err
+}
+
+// InvalidateTags atomically deletes every entry registered under any of tags.
+// In cluster mode the tag sets and their entries must share a hash slot, e.g.
+// by putting the same {hash tag} in every key and tag.
+func (cache *Cache) InvalidateTags(ctx *context.Context, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	_, err := cache.conn.Eval(ctx.Context, invalidateTags, tagKeys(tags), nil)
+	return errors.Wrap(err, "failed to invalidate tags "+strings.Join(tags, ", "))
+}
@@ -3,6 +3,7 @@ package context
 import (
 	"context"
 	"github.com/gin-gonic/gin"
+	"maps"
 	"sync"
 )
 
@@ -23,15 +24,24 @@ func NewContext() *Context {
 	}
 }
 
+// NewContextFromGinContext copies the keys of ginCtx, such as the request ID,
+// into the Context and its std Context, as Set does. The std Context also
+// carries the values of the request context, such as the trace of the
+// request, but is not cancelled with the request.
 func NewContextFromGinContext(ginCtx *gin.Context) *Context {
+	ctx := context.Background()
+	if ginCtx.Request != nil {
+		ctx = context.WithoutCancel(ginCtx.Request.Context())
+	}
 	data := make(map[string]any)
 	for key, value := range ginCtx.Keys {
-		data[key] = value.(string)
+		data[key] = value
+		ctx = context.WithValue(ctx, key, value)
 	}
 	return &Context{
 		data:       &data,
 		mutex:      &sync.RWMutex{},
-		Context:    context.Background(),
+		Context:    ctx,
 		GinContext: ginCtx,
 	}
 }
@@ -54,3 +64,26 @@ func (c *Context) GetContextMap() map[string]any {
 	defer c.mutex.RUnlock()
 	return *c.data
 }
+
+// Clone returns a copy of the Context with its own data map, so values set on
+// the copy are not visible through the original.
+func (c *Context) Clone() *Context {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	data := maps.Clone(*c.data)
+	return &Context{
+		data:       &data,
+		mutex:      &sync.RWMutex{},
+		Context:    c.Context,
+		GinContext: c.GinContext,
+	}
+}
+
+// WithoutCancel returns a clone of the Context that is not cancelled when the
+// original is. Background workers use it to let in-flight work finish during
+// shutdown.
+func (c *Context) WithoutCancel() *Context {
+	clone := c.Clone()
+	clone.Context = context.WithoutCancel(clone.Context)
+	return clone
+}
@@ -0,0 +1,73 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"slices"
+)
+
+var aesBitSizeParams = []int{128, 192, 256}
+
+// aesKeyGenerator implements KeyGenerator for AES. Symmetric keys have no
+// public half, so only the first return value is set.
+type aesKeyGenerator struct {
+	Bits int
+}
+
+func (a *aesKeyGenerator) GenerateKeys() (string, string, error) {
+	key := make([]byte, a.Bits/8)
+	if _, err := rand.Read(key); err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), "", nil
+}
+
+// ParseAESKey decodes a base64 AES key as generated by the AES KeyGenerator.
+func ParseAESKey(key string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(aesBitSizeParams, len(decoded)*8) {
+		return nil, errors.New("invalid AES key size")
+	}
+	return decoded, nil
+}
+
+// AESGCMEncrypt encrypts plaintext with AES-GCM. The random nonce is prepended
+// to the returned ciphertext.
+func AESGCMEncrypt(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// AESGCMDecrypt decrypts a ciphertext produced by AESGCMEncrypt.
+func AESGCMDecrypt(key, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -10,6 +10,7 @@ type EncryptionAlgorithm string
 const (
 	EncryptionAlgorithmRSA     EncryptionAlgorithm = "RSA"
 	EncryptionAlgorithmED25519 EncryptionAlgorithm = "ED25519"
+	EncryptionAlgorithmAES     EncryptionAlgorithm = "AES"
 )
 
 // KeyGenerator defines the interface for key generation
@@ -36,6 +37,11 @@ func KeyGeneratorFactory(algo EncryptionAlgorithm, args ...interface{}) (KeyGene
 		return &rsaKeyGenerator{Bits: bits}, nil
 	case EncryptionAlgorithmED25519:
 		return &ed25519KeyGenerator{}, nil
+	case EncryptionAlgorithmAES:
+		if !slices.Contains(aesBitSizeParams, bits) {
+			return nil, errors.New("invalid bit size for AES")
+		}
+		return &aesKeyGenerator{Bits: bits}, nil
 	default:
 		return nil, errors.New("unsupported algorithm")
 	}
@@ -4,4 +4,8 @@ const (
 	ServiceName = "serviceName"
 	RequestId   = "requestId"
 	DbQueryId   = "dbQueryId"
+	Session     = "session"
+	DbTx        = "dbTx"
+	DbPrimary   = "dbPrimary"
+	DbQueryName = "dbQueryName"
 )
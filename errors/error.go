@@ -62,6 +62,11 @@ func (e *Tracer) Error() string {
 	return e.originalMessage
 }
 
+// Unwrap returns the wrapped error, so Is and As see through Wrap.
+func (e *Tracer) Unwrap() error {
+	return e.cause
+}
+
 // Unwrap returns the cause of the error if it's a Tracer.
 func Unwrap(err error) error {
 	if err == nil {
@@ -0,0 +1,264 @@
+package health
+
+import (
+	stdcontext "context"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Checker checks a single dependency, returning nil while it is usable.
+type Checker interface {
+	Check(ctx stdcontext.Context) error
+}
+
+// CheckerFunc adapts a function such as a Ping method to a Checker.
+type CheckerFunc func(ctx stdcontext.Context) error
+
+func (f CheckerFunc) Check(ctx stdcontext.Context) error {
+	return f(ctx)
+}
+
+type MonitorConfig struct {
+	// Interval between two checks, 10s by default.
+	Interval time.Duration `json:"interval"`
+	// Timeout of a single check, 2s by default.
+	Timeout time.Duration `json:"timeout"`
+	// FailureThreshold is the number of consecutive failed checks after which
+	// the dependency is reported unhealthy, 3 by default.
+	FailureThreshold int `json:"failure_threshold"`
+	// Optional dependencies are reported but do not fail readiness.
+	Optional bool `json:"optional"`
+}
+
+func (cfg MonitorConfig) withDefaults() MonitorConfig {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 3
+	}
+	return cfg
+}
+
+// Status is the last known state of a dependency.
+type Status struct {
+	Name                string        `json:"name"`
+	Healthy             bool          `json:"healthy"`
+	Optional            bool          `json:"optional"`
+	ConsecutiveFailures int           `json:"consecutiveFailures"`
+	Latency             time.Duration `json:"latency"`
+	LastCheck           time.Time     `json:"lastCheck"`
+	LastError           string        `json:"lastError,omitempty"`
+}
+
+// Monitor checks a dependency periodically and keeps its Status. A dependency
+// is unhealthy until its first successful check.
+type Monitor struct {
+	name    string
+	checker Checker
+	cfg     MonitorConfig
+	logger  *zap.Logger
+
+	mutex  sync.RWMutex
+	status Status
+}
+
+func NewMonitor(name string, checker Checker, cfg MonitorConfig, logger *zap.Logger) *Monitor {
+	cfg = cfg.withDefaults()
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Monitor{
+		name:    name,
+		checker: checker,
+		cfg:     cfg,
+		logger:  logger,
+		status:  Status{Name: name, Optional: cfg.Optional},
+	}
+}
+
+func (m *Monitor) Name() string {
+	return m.name
+}
+
+// Status returns a copy of the last known state of the dependency.
+func (m *Monitor) Status() Status {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.status
+}
+
+// Run checks the dependency every Interval until ctx is cancelled, starting
+// immediately.
+func (m *Monitor) Run(ctx *context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		m.Check(ctx)
+		select {
+		case <-ctx.Context.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check runs a single check and records its outcome.
+func (m *Monitor) Check(ctx *context.Context) Status {
+	checkCtx, cancel := stdcontext.WithTimeout(ctx.Context, m.cfg.Timeout)
+	start := time.Now()
+	err := m.checker.Check(checkCtx)
+	latency := time.Since(start)
+	cancel()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	wasHealthy := m.status.Healthy
+	m.status.Latency = latency
+	m.status.LastCheck = start
+	if err != nil {
+		m.status.ConsecutiveFailures++
+		m.status.LastError = err.Error()
+		if m.status.ConsecutiveFailures >= m.cfg.FailureThreshold {
+			m.status.Healthy = false
+		}
+	} else {
+		m.status.ConsecutiveFailures = 0
+		m.status.LastError = ""
+		m.status.Healthy = true
+	}
+
+	switch {
+	case wasHealthy && !m.status.Healthy:
+		m.logger.Error("Dependency became unhealthy",
+			zap.String("dependency", m.name),
+			zap.Int("consecutiveFailures", m.status.ConsecutiveFailures),
+			zap.Error(err),
+		)
+	case !wasHealthy && m.status.Healthy:
+		m.logger.Info("Dependency is healthy",
+			zap.String("dependency", m.name),
+			zap.Duration("latency", latency),
+		)
+	case err != nil:
+		m.logger.Warn("Health check failed",
+			zap.String("dependency", m.name),
+			zap.Int("consecutiveFailures", m.status.ConsecutiveFailures),
+			zap.Error(err),
+		)
+	}
+	return m.status
+}
+
+// Registry aggregates the monitors of all dependencies of a service.
+type Registry struct {
+	mutex    sync.RWMutex
+	monitors []*Monitor
+	// runCtx is the context of Run while it runs, nil otherwise.
+	runCtx *context.Context
+	wg     sync.WaitGroup
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds monitors to the registry. While Run runs, they start right
+// away.
+func (r *Registry) Register(monitors ...*Monitor) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.monitors = append(r.monitors, monitors...)
+	if r.runCtx != nil {
+		for _, monitor := range monitors {
+			r.start(monitor)
+		}
+	}
+}
+
+// Run runs every monitor, including those registered later, until ctx is
+// cancelled.
+func (r *Registry) Run(ctx *context.Context) {
+	r.mutex.Lock()
+	r.runCtx = ctx
+	for _, monitor := range r.monitors {
+		r.start(monitor)
+	}
+	r.mutex.Unlock()
+
+	<-ctx.Context.Done()
+	r.mutex.Lock()
+	r.runCtx = nil
+	r.mutex.Unlock()
+	r.wg.Wait()
+}
+
+// start runs monitor in the background until the context of Run is
+// cancelled. The caller holds the mutex.
+func (r *Registry) start(monitor *Monitor) {
+	ctx := r.runCtx
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		monitor.Run(ctx)
+	}()
+}
+
+// Statuses returns the state of every registered dependency.
+func (r *Registry) Statuses() []Status {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	statuses := make([]Status, len(r.monitors))
+	for i, monitor := range r.monitors {
+		statuses[i] = monitor.Status()
+	}
+	return statuses
+}
+
+// Ready reports whether every required dependency is healthy.
+func (r *Registry) Ready() bool {
+	return ready(r.Statuses())
+}
+
+func ready(statuses []Status) bool {
+	for _, status := range statuses {
+		if !status.Healthy && !status.Optional {
+			return false
+		}
+	}
+	return true
+}
+
+type report struct {
+	Status string   `json:"status"`
+	Checks []Status `json:"checks"`
+}
+
+// HealthzHandler reports the state of all dependencies. It answers 200 as long
+// as the process can serve it, so a failing dependency does not get the service
+// restarted.
+func (r *Registry) HealthzHandler() gin.HandlerFunc {
+	return func(ginCtx *gin.Context) {
+		ginCtx.JSON(http.StatusOK, report{Status: "ok", Checks: r.Statuses()})
+	}
+}
+
+// ReadyzHandler answers 503 while a required dependency is unhealthy, taking
+// the service out of load balancing until it recovers.
+func (r *Registry) ReadyzHandler() gin.HandlerFunc {
+	return func(ginCtx *gin.Context) {
+		statuses := r.Statuses()
+		if !ready(statuses) {
+			ginCtx.JSON(http.StatusServiceUnavailable, report{Status: "unavailable", Checks: statuses})
+			return
+		}
+		ginCtx.JSON(http.StatusOK, report{Status: "ok", Checks: statuses})
+	}
+}
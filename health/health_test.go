@@ -0,0 +1,58 @@
+package health_test
+
+import (
+	stdcontext "context"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/health"
+	"testing"
+	"time"
+)
+
+// signallingMonitor returns a monitor signalling its checks on the returned
+// channel.
+func signallingMonitor(name string) (*health.Monitor, <-chan struct{}) {
+	checked := make(chan struct{}, 1)
+	monitor := health.NewMonitor(name, health.CheckerFunc(func(stdcontext.Context) error {
+		select {
+		case checked <- struct{}{}:
+		default:
+		}
+		return nil
+	}), health.MonitorConfig{}, nil)
+	return monitor, checked
+}
+
+func waitFor(t *testing.T, signal <-chan struct{}, message string) {
+	t.Helper()
+	select {
+	case <-signal:
+	case <-time.After(time.Second):
+		t.Fatal(message)
+	}
+}
+
+func TestRegistryRunsMonitorsRegisteredLater(t *testing.T) {
+	registry := health.NewRegistry()
+	postgres, postgresChecked := signallingMonitor("postgres")
+	registry.Register(postgres)
+
+	ctx := context.NewContext()
+	var cancel stdcontext.CancelFunc
+	ctx.Context, cancel = stdcontext.WithCancel(ctx.Context)
+	done := make(chan struct{})
+	go func() {
+		registry.Run(ctx)
+		close(done)
+	}()
+	waitFor(t, postgresChecked, "the registered monitor was not run")
+
+	redis, redisChecked := signallingMonitor("redis")
+	registry.Register(redis)
+	waitFor(t, redisChecked, "the monitor registered after Run was not run")
+	if !registry.Ready() {
+		t.Error("expected the registry to be ready")
+	}
+
+	cancel()
+	waitFor(t, done, "Run did not return once cancelled")
+}
@@ -0,0 +1,6 @@
+package outbox
+
+import "errors"
+
+// ErrNoTransaction is returned by Write called outside of postgres.WithTx.
+var ErrNoTransaction = errors.New("outbox events must be written in a transaction")
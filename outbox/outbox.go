@@ -0,0 +1,177 @@
+package outbox
+
+import (
+	"fmt"
+	"github.com/NitinD97/common-utils/connectors/postgres"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/goccy/go-json"
+	"github.com/jackc/pgx/v5"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	// Table is the outbox table, "outbox" by default. See Schema.
+	Table string `json:"table"`
+	// Channel is notified when events are written, so a relay using a
+	// postgres.Listener publishes them without waiting for its next poll,
+	// "outbox" by default.
+	Channel string `json:"channel"`
+	// BatchSize is the number of events a relay publishes per transaction,
+	// 100 by default.
+	BatchSize int `json:"batch_size"`
+	// PollInterval between two polls of an idle relay, 1s by default.
+	PollInterval time.Duration `json:"poll_interval"`
+	// MaxAttempts is the number of times publishing an event is attempted
+	// before it is given up, 10 by default.
+	MaxAttempts int `json:"max_attempts"`
+	// BackoffMin and BackoffMax bound the exponential backoff between two
+	// attempts of an event, 1s and 5m by default.
+	BackoffMin time.Duration `json:"backoff_min"`
+	BackoffMax time.Duration `json:"backoff_max"`
+	// Retention is how long published events are kept, 7 days by default.
+	Retention time.Duration `json:"retention"`
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.Table == "" {
+		cfg.Table = "outbox"
+	}
+	if cfg.Channel == "" {
+		cfg.Channel = "outbox"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.BackoffMin <= 0 {
+		cfg.BackoffMin = time.Second
+	}
+	if cfg.BackoffMax <= 0 {
+		cfg.BackoffMax = 5 * time.Minute
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = 7 * 24 * time.Hour
+	}
+	return cfg
+}
+
+// table returns the quoted name of the table, optionally qualified by its
+// schema.
+func (cfg Config) table() string {
+	return pgx.Identifier(strings.Split(cfg.Table, ".")).Sanitize()
+}
+
+// Schema returns the statements creating the outbox table, to be added to the
+// migrations of the service.
+func Schema(table string) string {
+	quoted := pgx.Identifier(strings.Split(table, ".")).Sanitize()
+	index := func(suffix string) string {
+		return pgx.Identifier{strings.ReplaceAll(table, ".", "_") + suffix}.Sanitize()
+	}
+	return fmt.Sprintf(`CREATE TABLE %[1]s (
+    id              bigserial PRIMARY KEY,
+    aggregate_type  text NOT NULL,
+    aggregate_id    text NOT NULL,
+    event_type      text NOT NULL,
+    payload         jsonb NOT NULL,
+    request_id      text,
+    created_at      timestamptz NOT NULL DEFAULT now(),
+    attempts        integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    last_error      text,
+    sent_at         timestamptz,
+    failed_at       timestamptz
+);
+
+CREATE INDEX %[2]s ON %[1]s (id) WHERE sent_at IS NULL AND failed_at IS NULL;
+CREATE INDEX %[3]s ON %[1]s (aggregate_type, aggregate_id, id) WHERE sent_at IS NULL AND failed_at IS NULL;
+CREATE INDEX %[4]s ON %[1]s (sent_at) WHERE sent_at IS NOT NULL;
+`, quoted, index("_pending"), index("_aggregate_pending"), index("_sent"))
+}
+
+// Event is a change of an aggregate, such as an order, to be published.
+// Events of the same aggregate are published in the order of their ids, which
+// are assigned on insert, not on commit. Transactions writing events of the
+// same aggregate must therefore be serialized, e.g. by locking the aggregate
+// row with SELECT ... FOR UPDATE before writing.
+type Event struct {
+	AggregateType string
+	AggregateId   string
+	Type          string
+	// Payload is JSON encoded.
+	Payload any
+}
+
+// Message is an event as published by a relay. Events are published at least
+// once, so consumers should ignore messages whose Id they already handled.
+type Message struct {
+	Id            int64           `json:"id"`
+	AggregateType string          `json:"aggregateType"`
+	AggregateId   string          `json:"aggregateId"`
+	Type          string          `json:"type"`
+	Payload       json.RawMessage `json:"payload"`
+	CreatedAt     time.Time       `json:"createdAt"`
+}
+
+// Decode unmarshals the JSON payload of the message into value.
+func (m *Message) Decode(value any) error {
+	return json.Unmarshal(m.Payload, value)
+}
+
+// Writer adds events to the outbox.
+type Writer struct {
+	cfg Config
+}
+
+func NewWriter(cfg Config) *Writer {
+	return &Writer{cfg: cfg.withDefaults()}
+}
+
+// Write adds events to the outbox in the transaction carried by ctx, so they
+// are published if and only if the transaction commits. It returns
+// ErrNoTransaction if ctx was not passed by postgres.WithTx.
+func (w *Writer) Write(ctx *context.Context, events ...Event) error {
+	tx, ok := postgres.TxFromContext(ctx)
+	if !ok {
+		return ErrNoTransaction
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	requestId, _ := ctx.Get(enums.RequestId).(string)
+	columns := make([][]string, 5)
+	for _, event := range events {
+		payload, err := json.Marshal(event.Payload)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to encode %s event", event.Type))
+		}
+		columns[0] = append(columns[0], event.AggregateType)
+		columns[1] = append(columns[1], event.AggregateId)
+		columns[2] = append(columns[2], event.Type)
+		columns[3] = append(columns[3], string(payload))
+		columns[4] = append(columns[4], requestId)
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (aggregate_type, aggregate_id, event_type, payload, request_id)
+SELECT aggregate_type, aggregate_id, event_type, payload::jsonb, NULLIF(request_id, '')
+FROM unnest($1::text[], $2::text[], $3::text[], $4::text[], $5::text[])
+    WITH ORDINALITY AS e(aggregate_type, aggregate_id, event_type, payload, request_id, n)
+ORDER BY n`, w.cfg.table())
+	if _, err := tx.Exec(ctx.Context, query, columns[0], columns[1], columns[2], columns[3], columns[4]); err != nil {
+		return errors.Wrap(err, "failed to write outbox events")
+	}
+	// Delivered on commit, and only once per transaction for equal payloads.
+	if _, err := tx.Exec(ctx.Context, "SELECT pg_notify($1, '')", w.cfg.Channel); err != nil {
+		return errors.Wrap(err, "failed to notify outbox relay")
+	}
+	return nil
+}
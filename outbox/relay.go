@@ -0,0 +1,258 @@
+package outbox
+
+import (
+	"fmt"
+	"github.com/NitinD97/common-utils/connectors/postgres"
+	"github.com/NitinD97/common-utils/connectors/redis"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/enums"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"time"
+)
+
+// Publisher sends a message to consumers.
+type Publisher interface {
+	Publish(ctx *context.Context, msg *Message) error
+}
+
+// PublisherFunc adapts a function to a Publisher.
+type PublisherFunc func(ctx *context.Context, msg *Message) error
+
+func (f PublisherFunc) Publish(ctx *context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+// NewStreamPublisher appends messages to the Redis stream named prefix
+// followed by their aggregate type, e.g. "events:order", trimmed to roughly
+// maxLen entries. Consumers read them with a redis.StreamConsumer[Message].
+// It needs the go-redis driver.
+func NewStreamPublisher(cache *redis.Cache, prefix string, maxLen int64) Publisher {
+	return PublisherFunc(func(ctx *context.Context, msg *Message) error {
+		_, err := redis.NewStreamProducer[*Message](cache, prefix+msg.AggregateType, maxLen).Publish(ctx, msg)
+		return err
+	})
+}
+
+// NewPubSubPublisher publishes messages on the Redis channel named prefix
+// followed by their aggregate type. Pub/sub drops messages while no consumer
+// is subscribed, prefer streams unless losing events is acceptable. It needs
+// the go-redis driver.
+func NewPubSubPublisher(cache *redis.Cache, prefix string) Publisher {
+	return PublisherFunc(func(ctx *context.Context, msg *Message) error {
+		return cache.Publish(ctx, prefix+msg.AggregateType, msg)
+	})
+}
+
+// Relay publishes the events of the outbox. Several relays can run at once,
+// each event is locked by the relay publishing it.
+type Relay struct {
+	pool      *pgxpool.Pool
+	publisher Publisher
+	cfg       Config
+	logger    *zap.Logger
+	wake      chan struct{}
+}
+
+type RelayOption func(*Relay)
+
+// WithListener wakes the relay up as soon as events are committed, through
+// the NOTIFY sent by Writer. It must be applied before listener runs.
+func WithListener(listener *postgres.Listener) RelayOption {
+	return func(r *Relay) {
+		listener.Handle(r.cfg.Channel, func(*context.Context, *postgres.Notification) error {
+			r.notify()
+			return nil
+		})
+		listener.OnGap(func(*context.Context, []string) {
+			r.notify()
+		})
+	}
+}
+
+func NewRelay(pool *pgxpool.Pool, publisher Publisher, cfg Config, logger *zap.Logger, opts ...RelayOption) *Relay {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	relay := &Relay{
+		pool:      pool,
+		publisher: publisher,
+		cfg:       cfg.withDefaults(),
+		logger:    logger,
+		wake:      make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(relay)
+	}
+	return relay
+}
+
+func (r *Relay) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes events until ctx is cancelled. It polls every PollInterval,
+// right away when notified through WithListener, and deletes published events
+// older than Retention once an hour.
+func (r *Relay) Run(ctx *context.Context) error {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+	var lastCleanup time.Time
+	for {
+		published, err := r.RelayOnce(ctx)
+		if ctx.Context.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			r.logger.Error("Outbox relay failed", zap.Error(err))
+		}
+		if time.Since(lastCleanup) >= time.Hour {
+			if err := r.cleanup(ctx); err != nil {
+				r.logger.Error("Outbox cleanup failed", zap.Error(err))
+			}
+			lastCleanup = time.Now()
+		}
+		// Each batch holds one event per aggregate, so keep going while
+		// events are published.
+		if err == nil && published > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Context.Done():
+			return nil
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// row is an event read back from the outbox.
+type row struct {
+	Id            int64     `db:"id"`
+	AggregateType string    `db:"aggregate_type"`
+	AggregateId   string    `db:"aggregate_id"`
+	EventType     string    `db:"event_type"`
+	Payload       []byte    `db:"payload"`
+	RequestId     string    `db:"request_id"`
+	CreatedAt     time.Time `db:"created_at"`
+	Attempts      int       `db:"attempts"`
+}
+
+// RelayOnce publishes a batch of due events in a transaction holding their
+// row locks, and returns how many it published. Only the earliest pending
+// event of each aggregate is due, so an event waiting for a retry or locked by
+// another relay holds back the later events of its aggregate but not those of
+// other aggregates. Events failing to publish are retried with backoff and
+// given up after MaxAttempts.
+func (r *Relay) RelayOnce(ctx *context.Context) (int, error) {
+	published := 0
+	err := postgres.WithTx(ctx, r.pool, postgres.TxOptions{}, func(ctx *context.Context, tx pgx.Tx) error {
+		published = 0
+		rows, err := r.lockDue(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		var sent []int64
+		for _, row := range rows {
+			if err := r.publish(ctx, row); err != nil {
+				if err := r.fail(ctx, tx, row, err); err != nil {
+					return err
+				}
+				continue
+			}
+			sent = append(sent, row.Id)
+		}
+
+		if len(sent) > 0 {
+			query := fmt.Sprintf("UPDATE %s SET sent_at = now() WHERE id = ANY($1)", r.cfg.table())
+			if _, err := tx.Exec(ctx.Context, query, sent); err != nil {
+				return errors.Wrap(err, "failed to mark outbox events as sent")
+			}
+		}
+		published = len(sent)
+		return nil
+	})
+	return published, err
+}
+
+// lockDue locks up to BatchSize due events that have no earlier pending event
+// in their aggregate. Filtering before LIMIT keeps the held back successors of
+// a failing event from filling the batch.
+func (r *Relay) lockDue(ctx *context.Context, tx pgx.Tx) ([]row, error) {
+	query := fmt.Sprintf(`SELECT o.id, o.aggregate_type, o.aggregate_id, o.event_type, o.payload,
+    COALESCE(o.request_id, '') AS request_id, o.created_at, o.attempts
+FROM %[1]s o
+WHERE o.sent_at IS NULL AND o.failed_at IS NULL AND o.next_attempt_at <= now()
+    AND NOT EXISTS (
+        SELECT 1 FROM %[1]s earlier
+        WHERE earlier.aggregate_type = o.aggregate_type AND earlier.aggregate_id = o.aggregate_id
+            AND earlier.id < o.id AND earlier.sent_at IS NULL AND earlier.failed_at IS NULL
+    )
+ORDER BY o.id
+LIMIT $1
+FOR UPDATE OF o SKIP LOCKED`, r.cfg.table())
+	rows, err := postgres.QueryAll[row](ctx, tx, query, r.cfg.BatchSize)
+	return rows, errors.Wrap(err, "failed to read outbox events")
+}
+
+func (r *Relay) publish(ctx *context.Context, row row) error {
+	msgCtx := ctx.Clone()
+	if row.RequestId != "" {
+		msgCtx.Set(enums.RequestId, row.RequestId)
+	}
+	return r.publisher.Publish(msgCtx, &Message{
+		Id:            row.Id,
+		AggregateType: row.AggregateType,
+		AggregateId:   row.AggregateId,
+		Type:          row.EventType,
+		Payload:       row.Payload,
+		CreatedAt:     row.CreatedAt,
+	})
+}
+
+// fail schedules the next attempt of row, or gives it up after MaxAttempts.
+func (r *Relay) fail(ctx *context.Context, tx pgx.Tx, row row, publishErr error) error {
+	attempts := row.Attempts + 1
+	backoff := r.cfg.BackoffMin
+	for i := 1; i < attempts && backoff < r.cfg.BackoffMax; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, r.cfg.BackoffMax)
+	givenUp := attempts >= r.cfg.MaxAttempts
+
+	query := fmt.Sprintf(`UPDATE %s
+SET attempts = $2, last_error = $3, next_attempt_at = now() + make_interval(secs => $4),
+    failed_at = CASE WHEN $5 THEN now() END
+WHERE id = $1`, r.cfg.table())
+	if _, err := tx.Exec(ctx.Context, query, row.Id, attempts, publishErr.Error(), backoff.Seconds(), givenUp); err != nil {
+		return errors.Wrap(err, "failed to record outbox publish failure")
+	}
+
+	fields := []zap.Field{
+		zap.Int64("eventId", row.Id),
+		zap.String("aggregateType", row.AggregateType),
+		zap.String("aggregateId", row.AggregateId),
+		zap.String("eventType", row.EventType),
+		zap.Int("attempts", attempts),
+		zap.Any(enums.RequestId, row.RequestId),
+		zap.Error(publishErr),
+	}
+	if givenUp {
+		r.logger.Error("Outbox event given up", fields...)
+	} else {
+		r.logger.Warn("Outbox event publish failed, retrying", append(fields, zap.Duration("retryIn", backoff))...)
+	}
+	return nil
+}
+
+func (r *Relay) cleanup(ctx *context.Context) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE sent_at < now() - make_interval(secs => $1)", r.cfg.table())
+	_, err := postgres.Exec(ctx, r.pool, query, r.cfg.Retention.Seconds())
+	return err
+}
@@ -0,0 +1,115 @@
+package outbox_test
+
+import (
+	"github.com/NitinD97/common-utils/connectors/postgres"
+	"github.com/NitinD97/common-utils/connectors/postgres/pgtest"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/errors"
+	"github.com/NitinD97/common-utils/outbox"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingPublisher records the types of the events it publishes, failing
+// those listed in failing.
+type recordingPublisher struct {
+	mutex     sync.Mutex
+	published []string
+	failing   map[string]bool
+}
+
+func (p *recordingPublisher) Publish(ctx *context.Context, msg *outbox.Message) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.failing[msg.Type] {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, msg.Type)
+	return nil
+}
+
+// newOutbox creates the outbox table and writes events to it in one
+// transaction.
+func newOutbox(t *testing.T, events ...outbox.Event) *pgxpool.Pool {
+	pool := pgtest.NewPool(t)
+	ctx := context.NewContext()
+	if _, err := pool.Exec(ctx.Context, outbox.Schema("outbox")); err != nil {
+		t.Fatal(err)
+	}
+	err := postgres.WithTx(ctx, pool, postgres.TxOptions{}, func(ctx *context.Context, tx pgx.Tx) error {
+		return outbox.NewWriter(outbox.Config{}).Write(ctx, events...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+var orderEvents = []outbox.Event{
+	{AggregateType: "order", AggregateId: "1", Type: "order1.created", Payload: map[string]int{"id": 1}},
+	{AggregateType: "order", AggregateId: "1", Type: "order1.paid", Payload: map[string]int{"id": 1}},
+	{AggregateType: "order", AggregateId: "2", Type: "order2.created", Payload: map[string]int{"id": 2}},
+}
+
+// relayOnce runs RelayOnce and checks how many events it published.
+func relayOnce(t *testing.T, relay *outbox.Relay, want int) {
+	t.Helper()
+	published, err := relay.RelayOnce(context.NewContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published != want {
+		t.Fatalf("published %d events, want %d", published, want)
+	}
+}
+
+func TestRelayHoldsBackLaterEventsOfAggregate(t *testing.T) {
+	pool := newOutbox(t, orderEvents...)
+	publisher := &recordingPublisher{failing: map[string]bool{"order1.created": true}}
+	relay := outbox.NewRelay(pool, publisher, outbox.Config{BackoffMin: time.Hour}, nil)
+
+	// order1.paid waits for order1.created, order 2 does not.
+	relayOnce(t, relay, 1)
+	relayOnce(t, relay, 0)
+
+	publisher.mutex.Lock()
+	publisher.failing = nil
+	publisher.mutex.Unlock()
+	if _, err := pool.Exec(t.Context(), "UPDATE outbox SET next_attempt_at = now()"); err != nil {
+		t.Fatal(err)
+	}
+	relayOnce(t, relay, 1)
+	relayOnce(t, relay, 1)
+	relayOnce(t, relay, 0)
+
+	want := []string{"order2.created", "order1.created", "order1.paid"}
+	if !slices.Equal(publisher.published, want) {
+		t.Fatalf("published %v, want %v", publisher.published, want)
+	}
+}
+
+func TestRelayReleasesEventsAfterGivenUpEvent(t *testing.T) {
+	pool := newOutbox(t, orderEvents...)
+	publisher := &recordingPublisher{failing: map[string]bool{"order1.created": true}}
+	relay := outbox.NewRelay(pool, publisher, outbox.Config{MaxAttempts: 1}, nil)
+
+	relayOnce(t, relay, 1)
+	relayOnce(t, relay, 1)
+	relayOnce(t, relay, 0)
+
+	want := []string{"order2.created", "order1.paid"}
+	if !slices.Equal(publisher.published, want) {
+		t.Fatalf("published %v, want %v", publisher.published, want)
+	}
+	var failed bool
+	err := pool.QueryRow(t.Context(),
+		"SELECT failed_at IS NOT NULL AND sent_at IS NULL FROM outbox WHERE event_type = 'order1.created'",
+	).Scan(&failed)
+	if err != nil || !failed {
+		t.Fatalf("expected order1.created to be given up, got %v, %v", failed, err)
+	}
+}
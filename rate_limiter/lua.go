@@ -1,12 +1,12 @@
 package rate_limiter
 
 import (
-	"github.com/redis/rueidis"
+	"github.com/NitinD97/common-utils/connectors/redis"
 )
 
 // Copyright (c) 2017 Pavel Pravosud
 // https://github.com/rwz/redis-gcra/blob/master/vendor/perform_gcra_ratelimit.lua
-var allowN = rueidis.NewLuaScript(`
+var allowN = redis.NewScript(`
 -- this script has side-effects, so it requires replicate commands mode
 redis.replicate_commands()
 local rate_limit_key = KEYS[1]
@@ -56,7 +56,7 @@ local retry_after = -1
 return {cost, remaining, tostring(retry_after), tostring(reset_after)}
 `)
 
-var allowAtMost = rueidis.NewLuaScript(`
+var allowAtMost = redis.NewScript(`
 -- this script has side-effects, so it requires replicate commands mode
 redis.replicate_commands()
 local rate_limit_key = KEYS[1]
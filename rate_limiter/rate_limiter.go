@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/NitinD97/common-utils/connectors/redis"
 	"github.com/alphadose/haxmap"
 	"github.com/redis/rueidis"
 )
@@ -74,7 +75,7 @@ func PerDay(rate int) Limit {
 
 // Limiter controls how frequently events are allowed to happen.
 type Limiter struct {
-	rdb          rueidis.Client
+	rdb          redis.Connector
 	limit        Limit
 	customLimits *haxmap.Map[string, Limit]
 	prefix       string
@@ -110,8 +111,14 @@ func defaultLimits() Limit {
 
 // NewLimiter returns a new Limiter.
 func NewLimiter(rdb rueidis.Client, opts ...LimiterOption) *Limiter {
+	return NewLimiterWithConnector(redis.NewRueidisConnector(rdb), opts...)
+}
+
+// NewLimiterWithConnector returns a new Limiter running on either redis driver,
+// e.g. the one behind a redis.Cache.
+func NewLimiterWithConnector(conn redis.Connector, opts ...LimiterOption) *Limiter {
 	limiter := &Limiter{
-		rdb:    rdb,
+		rdb:    conn,
 		limit:  defaultLimits(),
 		prefix: redisPrefix,
 	}
@@ -145,7 +152,7 @@ func (l Limiter) AllowN(
 		strconv.Itoa(limit.Rate),
 		strconv.FormatFloat(limit.Period.Seconds(), 'f', 2, 32),
 		strconv.Itoa(n)}
-	result, err := allowN.Exec(ctx, l.rdb, []string{l.prefix + key}, values).AsFloatSlice()
+	result, err := evalFloats(ctx, l.rdb, allowN, []string{l.prefix + key}, values)
 	if err != nil {
 		return nil, err
 	}
@@ -174,7 +181,7 @@ func (l Limiter) AllowAtMost(
 		strconv.Itoa(limit.Rate),
 		strconv.FormatFloat(limit.Period.Seconds(), 'f', 2, 32),
 		strconv.Itoa(n)}
-	result, err := allowAtMost.Exec(ctx, l.rdb, []string{l.prefix + key}, values).AsFloatSlice()
+	result, err := evalFloats(ctx, l.rdb, allowAtMost, []string{l.prefix + key}, values)
 	if err != nil {
 		return nil, err
 	}
@@ -194,8 +201,36 @@ func (l Limiter) AllowAtMost(
 
 // Reset gets a key and reset all limitations and previous usages
 func (l *Limiter) Reset(ctx context.Context, key string) error {
-	cmd := l.rdb.B().Del().Key(l.prefix + key).Build()
-	return l.rdb.Do(ctx, cmd).Error()
+	return l.rdb.Del(ctx, l.prefix+key)
+}
+
+// evalFloats runs one of the GCRA scripts, which reply with an array of
+// integers and numeric strings.
+func evalFloats(ctx context.Context, conn redis.Connector, script *redis.Script, keys []string, args []string) ([]float64, error) {
+	reply, err := conn.Eval(ctx, script, keys, args)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 4 {
+		return nil, fmt.Errorf("unexpected rate limiter reply %v", reply)
+	}
+	result := make([]float64, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case int64:
+			result[i] = float64(v)
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = f
+		default:
+			return nil, fmt.Errorf("unexpected rate limiter reply %v", reply)
+		}
+	}
+	return result, nil
 }
 
 func dur(f float64) time.Duration {
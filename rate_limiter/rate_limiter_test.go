@@ -0,0 +1,71 @@
+package rate_limiter_test
+
+import (
+	stdcontext "context"
+	"github.com/NitinD97/common-utils/connectors/redis/redistest"
+	"github.com/NitinD97/common-utils/rate_limiter"
+	"testing"
+)
+
+func limiters(opts ...rate_limiter.LimiterOption) map[string]func(t *testing.T) (*rate_limiter.Limiter, *redistest.Server) {
+	return map[string]func(t *testing.T) (*rate_limiter.Limiter, *redistest.Server){
+		"go-redis": func(t *testing.T) (*rate_limiter.Limiter, *redistest.Server) {
+			cache, server := redistest.NewCache(t)
+			return rate_limiter.NewLimiterWithConnector(cache.Connector(), opts...), server
+		},
+		"rueidis": func(t *testing.T) (*rate_limiter.Limiter, *redistest.Server) {
+			client, server := redistest.NewRueidisClient(t)
+			return rate_limiter.NewLimiter(client, opts...), server
+		},
+	}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	for driver, newLimiter := range limiters(rate_limiter.WithRateLimit(rate_limiter.PerSecond(2))) {
+		t.Run(driver, func(t *testing.T) {
+			limiter, server := newLimiter(t)
+			ctx := stdcontext.Background()
+
+			for i := 0; i < 2; i++ {
+				result, err := limiter.Allow(ctx, "client")
+				if err != nil || result.Allowed != 1 {
+					t.Fatalf("request %d: got %+v, %v", i, result, err)
+				}
+			}
+			result, err := limiter.Allow(ctx, "client")
+			if err != nil || result.Allowed != 0 || result.RetryAfter <= 0 {
+				t.Fatalf("expected the burst to be exhausted, got %+v, %v", result, err)
+			}
+
+			// The scripts read TIME, so they only see time pass through the
+			// clock of the server.
+			server.FastForward(result.RetryAfter)
+			result, err = limiter.Allow(ctx, "client")
+			if err != nil || result.Allowed != 1 {
+				t.Fatalf("expected a request to be allowed after RetryAfter, got %+v, %v", result, err)
+			}
+		})
+	}
+}
+
+func TestLimiterAllowAtMostAndReset(t *testing.T) {
+	for driver, newLimiter := range limiters() {
+		t.Run(driver, func(t *testing.T) {
+			limiter, _ := newLimiter(t)
+			ctx := stdcontext.Background()
+			limit := rate_limiter.PerMinute(5)
+
+			result, err := limiter.AllowAtMost(ctx, "batch", limit, 8)
+			if err != nil || result.Allowed != 5 || result.Remaining != 0 {
+				t.Fatalf("got %+v, %v", result, err)
+			}
+			if err := limiter.Reset(ctx, "batch"); err != nil {
+				t.Fatal(err)
+			}
+			result, err = limiter.AllowAtMost(ctx, "batch", limit, 3)
+			if err != nil || result.Allowed != 3 || result.Remaining != 2 {
+				t.Fatalf("got %+v, %v", result, err)
+			}
+		})
+	}
+}
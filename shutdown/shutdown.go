@@ -0,0 +1,135 @@
+package shutdown
+
+import (
+	stdcontext "context"
+	"github.com/NitinD97/common-utils/context"
+	"github.com/NitinD97/common-utils/errors"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Closer releases a resource, giving up once ctx is done.
+type Closer interface {
+	Close(ctx stdcontext.Context) error
+}
+
+// CloserFunc adapts a function such as http.Server.Shutdown to a Closer.
+type CloserFunc func(ctx stdcontext.Context) error
+
+func (f CloserFunc) Close(ctx stdcontext.Context) error {
+	return f(ctx)
+}
+
+type Config struct {
+	// Timeout bounds the whole shutdown, 30s by default. It should stay below
+	// the grace period of the orchestrator, e.g. terminationGracePeriodSeconds.
+	Timeout time.Duration `json:"timeout"`
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	return cfg
+}
+
+type namedCloser struct {
+	name   string
+	closer Closer
+}
+
+// Manager closes the resources of a service on shutdown, in the reverse order
+// of their registration: a server registered after the connectors it uses is
+// stopped before them.
+type Manager struct {
+	cfg    Config
+	logger *zap.Logger
+
+	mutex   sync.Mutex
+	closers []namedCloser
+	once    sync.Once
+	err     error
+}
+
+func NewManager(cfg Config, logger *zap.Logger) *Manager {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Manager{
+		cfg:    cfg.withDefaults(),
+		logger: logger,
+	}
+}
+
+// Register adds a resource to close on shutdown.
+func (m *Manager) Register(name string, closer Closer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.closers = append(m.closers, namedCloser{name: name, closer: closer})
+}
+
+// Wait blocks until the process receives SIGINT or SIGTERM or ctx is
+// cancelled, then shuts down.
+func (m *Manager) Wait(ctx *context.Context) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case sig := <-signals:
+		m.logger.Info("Shutdown signal received", zap.String("signal", sig.String()))
+	case <-ctx.Context.Done():
+	}
+	return m.Shutdown(ctx.WithoutCancel())
+}
+
+// Shutdown closes every registered resource one after the other, within
+// Timeout. A resource failing to close does not stop the others from being
+// closed; the first error is returned. Only the first call closes, later ones
+// return its result.
+func (m *Manager) Shutdown(ctx *context.Context) error {
+	m.once.Do(func() {
+		m.err = m.shutdown(ctx)
+	})
+	return m.err
+}
+
+func (m *Manager) shutdown(ctx *context.Context) error {
+	m.mutex.Lock()
+	closers := append([]namedCloser(nil), m.closers...)
+	m.mutex.Unlock()
+
+	start := time.Now()
+	shutdownCtx, cancel := stdcontext.WithTimeout(ctx.Context, m.cfg.Timeout)
+	defer cancel()
+
+	var failed error
+	for i := len(closers) - 1; i >= 0; i-- {
+		closer := closers[i]
+		closeStart := time.Now()
+		if err := closer.closer.Close(shutdownCtx); err != nil {
+			m.logger.Error("Failed to close resource",
+				zap.String("resource", closer.name),
+				zap.Duration("duration", time.Since(closeStart)),
+				zap.Error(err),
+			)
+			if failed == nil {
+				failed = errors.Wrap(err, "failed to close "+closer.name)
+			}
+			continue
+		}
+		m.logger.Info("Resource closed",
+			zap.String("resource", closer.name),
+			zap.Duration("duration", time.Since(closeStart)),
+		)
+	}
+	m.logger.Info("Shutdown finished",
+		zap.Duration("duration", time.Since(start)),
+		zap.Bool("failed", failed != nil),
+	)
+	return failed
+}